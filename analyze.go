@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Luzifer/named-blacklist/pkg/analysis"
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+)
+
+// runAnalyze fetches all providers and prints how they overlap and
+// contribute to the blacklist. State is neither read nor written.
+func runAnalyze() error {
+	results, err := generator.CollectEntries(version, conf.Providers,
+		generator.WithConcurrency(conf.Fetch.Concurrency),
+		generator.WithHostLimits(conf.Fetch.HostConcurrency, conf.Fetch.HostRateLimit),
+		generator.WithStripWWW(conf.Normalize.StripWWW),
+	)
+	if err != nil {
+		return fmt.Errorf("fetching providers: %w", err)
+	}
+
+	result := analysis.Analyze(results, generator.WithScoreThreshold(conf.ScoreThreshold))
+	if err = result.Write(os.Stdout, cfg.AnalyzeUnique); err != nil {
+		return fmt.Errorf("printing analysis: %w", err)
+	}
+
+	return nil
+}
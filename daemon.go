@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+	"github.com/Luzifer/named-blacklist/pkg/metrics"
+)
+
+const (
+	// reloadDebounce collects multiple file events (i.e. editors writing
+	// a temporary file and renaming it) into a single reload
+	reloadDebounce    = time.Second
+	readHeaderTimeout = 10 * time.Second
+)
+
+// watchedFiles contains the config files to reload the config for
+var watchedFiles atomic.Pointer[[]string]
+
+// runDaemon generates the blacklist in the configured interval and
+// reloads the config on SIGHUP or when one of the config files changes.
+// Failed generations and reloads are logged without stopping the loop.
+// Only generations after a reload use the cache: the cached entries are
+// kept until the next interval to reuse them on further reloads while
+// the config is edited without holding a copy of all entries forever.
+func runDaemon() error {
+	var (
+		cache     = generator.NewCache()
+		collector = newCollector()
+		reload    = make(chan struct{}, 1)
+		stop      = make(chan os.Signal, 1)
+	)
+
+	if cfg.MetricsListen != "" {
+		go serveMetrics(collector)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		for range hup {
+			logrus.Info("received SIGHUP, reloading config")
+			requestReload(reload)
+		}
+	}()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating config watcher: %w", err)
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			logrus.WithError(err).Error("closing config watcher")
+		}
+	}()
+
+	go watchConfig(watcher, reload)
+	updateWatches(watcher, nil, conf.Files)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	generate := func(cache *generator.Cache) {
+		if err := runOnce(cache, collector); err != nil {
+			logrus.WithError(err).Error("running blacklist generation")
+		}
+	}
+
+	generate(nil)
+
+	for {
+		select {
+		case <-ticker.C:
+			// Fetch all providers again and free the cached entries
+			cache.Clear()
+			generate(nil)
+
+		case <-reload:
+			newConf, err := config.LoadConfigFile(cfg.Config)
+			if err != nil {
+				logConfigProblems(err)
+				continue
+			}
+
+			updateWatches(watcher, conf.Files, newConf.Files)
+			conf = newConf
+			logrus.Info("config reloaded")
+
+			// Keep the results of unchanged providers and generate the
+			// blacklist using the new config right away
+			cache.Prune(conf.Providers, generatorOptions()...)
+			generate(cache)
+
+		case sig := <-stop:
+			logrus.WithField("signal", sig).Info("shutting down")
+			return nil
+		}
+	}
+}
+
+// logConfigProblems reports a failed reload, keeping the old config
+func logConfigProblems(err error) {
+	var problems config.ValidationErrors
+	if !errors.As(err, &problems) {
+		logrus.WithError(err).Error("reloading config, keeping previous config")
+		return
+	}
+
+	for _, p := range problems {
+		logrus.WithFields(logrus.Fields{
+			"file":   p.File,
+			"line":   p.Line,
+			"column": p.Column,
+		}).Error(p.Message)
+	}
+	logrus.WithField("problems", len(problems)).Error("reloading config, keeping previous config")
+}
+
+// requestReload queues a reload unless one is already queued
+func requestReload(reload chan struct{}) {
+	select {
+	case reload <- struct{}{}:
+	default:
+	}
+}
+
+func serveMetrics(collector *metrics.Collector) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(collector.Gatherer(), promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              cfg.MetricsListen,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	logrus.WithField("addr", cfg.MetricsListen).Info("serving metrics")
+	if err := server.ListenAndServe(); err != nil {
+		logrus.WithError(err).Error("serving metrics")
+	}
+}
+
+// updateWatches watches the directories of the config files as editors
+// tend to replace files instead of writing them
+func updateWatches(watcher *fsnotify.Watcher, oldFiles, newFiles []string) {
+	dirs := func(files []string) (out []string) {
+		for _, f := range files {
+			if d := filepath.Dir(f); !slices.Contains(out, d) {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+
+	oldDirs, newDirs := dirs(oldFiles), dirs(newFiles)
+
+	for _, d := range oldDirs {
+		if !slices.Contains(newDirs, d) {
+			if err := watcher.Remove(d); err != nil {
+				logrus.WithError(err).WithField("dir", d).Debug("removing config watch")
+			}
+		}
+	}
+
+	for _, d := range newDirs {
+		if !slices.Contains(oldDirs, d) {
+			if err := watcher.Add(d); err != nil {
+				logrus.WithError(err).WithField("dir", d).Error("watching config directory")
+			}
+		}
+	}
+
+	watchedFiles.Store(&newFiles)
+}
+
+// watchConfig requests a reload when one of the config files changes
+func watchConfig(watcher *fsnotify.Watcher, reload chan struct{}) {
+	var debounce *time.Timer
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			files := watchedFiles.Load()
+			if files == nil || !slices.Contains(*files, ev.Name) {
+				continue
+			}
+
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(reloadDebounce, func() {
+				logrus.WithField("file", ev.Name).Info("config file changed, reloading config")
+				requestReload(reload)
+			})
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.WithError(err).Error("watching config files")
+		}
+	}
+}
@@ -2,21 +2,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/Luzifer/rconfig/v2"
 	"github.com/sirupsen/logrus"
 
 	"github.com/Luzifer/named-blacklist/pkg/config"
 	"github.com/Luzifer/named-blacklist/pkg/generator"
+	"github.com/Luzifer/named-blacklist/pkg/metrics"
+	"github.com/Luzifer/named-blacklist/pkg/output"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+	"github.com/Luzifer/named-blacklist/pkg/report"
+	"github.com/Luzifer/named-blacklist/pkg/state"
 )
 
 var (
 	cfg = struct {
-		Config         string `flag:"config" default:"config.yaml" description:"Config file to use for generating the file"`
-		LogLevel       string `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
-		VersionAndExit bool   `flag:"version" default:"false" description:"Prints current version and exits"`
+		AnalyzeUnique  bool          `flag:"analyze-unique" default:"false" description:"List the domains unique to each provider in the analyze command"`
+		Config         string        `flag:"config" default:"config.yaml" description:"Config file to use for generating the file"`
+		Interval       time.Duration `flag:"interval" default:"0" description:"Run as daemon and generate the blacklist in this interval (0 = run once)"`
+		LogLevel       string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
+		MetricsFile    string        `flag:"metrics-file" default:"" description:"Write Prometheus metrics to this file (node-exporter textfile collector)"`
+		MetricsListen  string        `flag:"metrics-listen" default:"" description:"Serve Prometheus metrics on this address in daemon mode (i.e. :9090)"`
+		Rejections     string        `flag:"rejections" default:"" description:"Write the lines rejected by the providers as JSON lines to this file"`
+		Report         string        `flag:"report" default:"" description:"Write a JSON report about the run to this file"`
+		VersionAndExit bool          `flag:"version" default:"false" description:"Prints current version and exits"`
 	}{}
 
 	conf *config.File
@@ -51,18 +64,153 @@ func main() {
 		os.Exit(0)
 	}
 
+	var command string
+	// First argument is the program name
+	if args := rconfig.Args()[1:]; len(args) > 0 {
+		command = args[0]
+	}
+
+	if command == "validate" {
+		if err = runValidate(); err != nil {
+			logrus.WithError(err).Fatal("validating config file")
+		}
+		return
+	}
+
 	if conf, err = config.LoadConfigFile(cfg.Config); err != nil {
 		logrus.WithError(err).Fatal("reading config file")
 	}
 
-	blacklist, err := generator.GenerateBlacklist(version, conf.Providers)
+	switch command {
+	case "":
+		if cfg.Interval > 0 {
+			if err = runDaemon(); err != nil {
+				logrus.WithError(err).Fatal("running daemon")
+			}
+			return
+		}
+
+		if err = runOnce(nil, newCollector()); err != nil {
+			logrus.WithError(err).Fatal("running blacklist generation")
+		}
+
+	case "analyze":
+		if err = runAnalyze(); err != nil {
+			logrus.WithError(err).Fatal("analyzing providers")
+		}
+
+	default:
+		logrus.Fatalf("unknown command %q", command)
+	}
+}
+
+// newCollector creates the metrics collector, the last success
+// timestamps are restored from the metrics file of the previous run
+// to keep them when providers fail
+func newCollector() *metrics.Collector {
+	collector := metrics.New()
+
+	if cfg.MetricsFile != "" {
+		if err := collector.RestoreTextfile(cfg.MetricsFile); err != nil {
+			logrus.WithError(err).Warn("restoring previous metrics")
+		}
+	}
+
+	return collector
+}
+
+// runOnce generates the blacklist, updates the metrics and writes the
+// metrics file and report when configured
+func runOnce(cache *generator.Cache, collector *metrics.Collector) error {
+	var (
+		outputs   = make(map[string]int)
+		startedAt = time.Now()
+		stats     generator.RunStats
+	)
+
+	blacklist, err := run(&stats, outputs, cache)
+
+	collector.Update(stats, err, outputs, time.Now())
+	if cfg.MetricsFile != "" {
+		if werr := collector.WriteTextfile(cfg.MetricsFile); werr != nil {
+			logrus.WithError(werr).Error("writing metrics")
+		}
+	}
+
+	if cfg.Report != "" {
+		rep := report.New(version, startedAt, conf, stats, blacklist, outputs, err)
+		if werr := rep.WriteFile(cfg.Report); werr != nil {
+			logrus.WithError(werr).Error("writing report")
+		}
+	}
+
+	return err
+}
+
+// generatorOptions returns the options of the generator derived from
+// the current config
+func generatorOptions() []generator.Option {
+	return []generator.Option{
+		generator.WithConcurrency(conf.Fetch.Concurrency),
+		generator.WithHostLimits(conf.Fetch.HostConcurrency, conf.Fetch.HostRateLimit),
+		generator.WithRedirects(conf.Redirects, conf.TagRedirects),
+		generator.WithScoreThreshold(conf.ScoreThreshold),
+		generator.WithStripWWW(conf.Normalize.StripWWW),
+	}
+}
+
+func run(stats *generator.RunStats, outputs map[string]int, cache *generator.Cache) (blacklist []provider.Entry, err error) {
+	opts := generatorOptions()
+
+	if cache != nil {
+		opts = append(opts, generator.WithCache(cache))
+	}
+
+	if cfg.Rejections != "" {
+		rejections, err := report.NewRejectionLog(cfg.Rejections)
+		if err != nil {
+			return nil, fmt.Errorf("creating rejection log: %w", err)
+		}
+
+		defer func() {
+			if werr := rejections.Commit(); werr != nil {
+				logrus.WithError(werr).Error("writing rejections")
+			}
+		}()
+
+		opts = append(opts, generator.WithRejections(rejections.Add))
+	}
+
+	var store *state.Store
+	if conf.State.File != "" {
+		if store, err = state.Load(conf.State.File); err != nil {
+			return nil, fmt.Errorf("loading state: %w", err)
+		}
+		opts = append(opts, generator.WithState(store, conf.State.GracePeriod))
+	}
+
+	res, err := generator.New(append(opts, generator.WithAppVersion(version))...).Generate(context.Background(), conf.Providers)
+	*stats = res.Stats
 	if err != nil {
-		logrus.WithError(err).Fatal("generating blacklist")
+		return nil, fmt.Errorf("generating blacklist: %w", err)
+	}
+	blacklist = res.Blacklist
+
+	for _, out := range conf.Outputs {
+		n, err := output.Write(out, blacklist)
+		if err != nil {
+			return blacklist, fmt.Errorf("writing output %q: %w", output.Name(out), err)
+		}
+		outputs[output.Name(out)] = n
 	}
 
-	if err = conf.CompiledTemplate.Execute(os.Stdout, map[string]any{
-		"blacklist": blacklist,
-	}); err != nil {
-		logrus.WithError(err).Fatal("rendering blacklist")
+	if store != nil {
+		// Only persist the state after the outputs were written to retry
+		// the same transition on the next run otherwise
+		if err = store.Save(); err != nil {
+			return blacklist, fmt.Errorf("saving state: %w", err)
+		}
 	}
+
+	return blacklist, nil
 }
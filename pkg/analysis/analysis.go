@@ -0,0 +1,241 @@
+// Package analysis compares the provider results to find out how much
+// every provider contributes to the final blacklist.
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+)
+
+type (
+	// Provider contains the analysis of a single provider
+	Provider struct {
+		Name   string
+		Action config.ProviderAction
+		// Entries returned by the provider
+		Entries int
+		// UniqueDomains are listed by no other provider having the same
+		// action
+		UniqueDomains []string
+		// SolelyResponsible counts final blacklist entries listed by this
+		// provider only
+		SolelyResponsible int
+		// RemovalDelta is the change of the blacklist size when removing
+		// the provider
+		RemovalDelta int
+		// MinMatches is the currently configured min_matches
+		MinMatches int
+		// MinMatchesDelta maps alternative min_matches values to the
+		// change of the blacklist size when using them, it is nil when
+		// the blacklist is selected by score as min_matches has no
+		// effect then
+		MinMatchesDelta map[int]int
+	}
+
+	// Result contains the analysis of all providers
+	Result struct {
+		// Entries contained in the blacklist compiled from all providers
+		Entries int
+		// Jaccard contains the pairwise overlap (intersection over union)
+		// of the providers in the order of Providers. Providers having
+		// different actions are not compared, their overlap is NaN.
+		Jaccard [][]float64
+		// Providers in the order they were configured
+		Providers []Provider
+	}
+)
+
+// Analyze compares the given provider results. The blacklist is
+// compiled once with all providers, the entries are aggregated once
+// more to count them for every provider being removed or having its
+// min_matches changed using the given options. The min_matches changes
+// are skipped when a score threshold is set.
+func Analyze(results []generator.ProviderResult, opts ...generator.Option) Result {
+	var (
+		domains = make([]map[string]struct{}, len(results))
+		r       = Result{Providers: make([]Provider, len(results))}
+		scoring = generator.ScoringEnabled(opts...)
+	)
+
+	for i, res := range results {
+		domains[i] = make(map[string]struct{}, len(res.Entries))
+		for _, e := range res.Entries {
+			domains[i][e.Domain] = struct{}{}
+		}
+	}
+
+	baseline := generator.CompileBlacklist(results, opts...)
+	r.Entries = len(baseline)
+
+	selection := generator.NewSelection(results, opts...)
+
+	solely := make(map[string]int)
+	for _, e := range baseline {
+		if len(e.Providers) == 1 {
+			solely[e.Providers[0]]++
+		}
+	}
+
+	r.Jaccard = make([][]float64, len(results))
+	for i := range results {
+		r.Jaccard[i] = make([]float64, len(results))
+	}
+
+	// The overlap is symmetric: compute the upper triangle and mirror it
+	for i := range results {
+		for j := i; j < len(results); j++ {
+			v := math.NaN()
+			if results[i].Provider.Action == results[j].Provider.Action {
+				v = jaccard(domains[i], domains[j])
+			}
+			r.Jaccard[i][j], r.Jaccard[j][i] = v, v
+		}
+	}
+
+	for i, res := range results {
+		p := Provider{
+			Name:              res.Provider.Name,
+			Action:            res.Provider.Action,
+			Entries:           len(res.Entries),
+			SolelyResponsible: solely[res.Provider.Name],
+			MinMatches:        max(res.Provider.MinMatches, 1),
+		}
+
+		for domain := range domains[i] {
+			if !listedByOthers(results, domains, i, domain) {
+				p.UniqueDomains = append(p.UniqueDomains, domain)
+			}
+		}
+		slices.Sort(p.UniqueDomains)
+
+		p.RemovalDelta = selection.CountWithout(i) - r.Entries
+
+		if res.Provider.Action == config.ProviderActionBlacklist && !scoring {
+			p.MinMatchesDelta = make(map[int]int)
+			for _, mm := range []int{p.MinMatches - 1, p.MinMatches + 1} {
+				if mm < 1 {
+					continue
+				}
+
+				changed := res.Provider
+				changed.MinMatches = mm
+				p.MinMatchesDelta[mm] = selection.CountWith(i, changed) - r.Entries
+			}
+		}
+
+		r.Providers[i] = p
+	}
+
+	return r
+}
+
+// Write prints the analysis as human readable tables. When listUnique is
+// set the domains unique to each provider are printed too.
+func (r Result) Write(w io.Writer, listUnique bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //revive:disable-line:add-constant // Padding of the columns
+
+	fmt.Fprintf(tw, "Blacklist entries with all providers: %d\n\n", r.Entries)
+
+	fmt.Fprintln(tw, "Provider\tAction\tEntries\tUnique\tSolely responsible\tWithout provider\tmin_matches")
+	for _, p := range r.Providers {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%+d\t%s\n",
+			p.Name, p.Action, p.Entries, len(p.UniqueDomains), p.SolelyResponsible, p.RemovalDelta, formatMinMatches(p))
+	}
+
+	fmt.Fprintln(tw, "\nOverlap (Jaccard)")
+
+	header := []string{""}
+	for i := range r.Providers {
+		header = append(header, fmt.Sprintf("#%d", i+1))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for i, p := range r.Providers {
+		row := []string{fmt.Sprintf("#%d %s", i+1, p.Name)}
+		for j := range r.Providers {
+			if math.IsNaN(r.Jaccard[i][j]) {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, fmt.Sprintf("%.3f", r.Jaccard[i][j]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("writing analysis: %w", err)
+	}
+
+	if !listUnique {
+		return nil
+	}
+
+	for _, p := range r.Providers {
+		if _, err := fmt.Fprintf(w, "\nDomains unique to %s:\n", p.Name); err != nil {
+			return fmt.Errorf("writing analysis: %w", err)
+		}
+
+		for _, d := range p.UniqueDomains {
+			if _, err := fmt.Fprintln(w, d); err != nil {
+				return fmt.Errorf("writing analysis: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatMinMatches describes the current min_matches value of the
+// provider and the change of the blacklist size for alternative values
+func formatMinMatches(p Provider) string {
+	if p.MinMatchesDelta == nil {
+		return "-"
+	}
+
+	values := []string{fmt.Sprintf("%d (current)", p.MinMatches)}
+	for _, mm := range []int{p.MinMatches - 1, p.MinMatches + 1} {
+		if delta, ok := p.MinMatchesDelta[mm]; ok {
+			values = append(values, fmt.Sprintf("%d: %+d", mm, delta))
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+
+	var intersection int
+	for domain := range a {
+		if _, ok := b[domain]; ok {
+			intersection++
+		}
+	}
+
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// listedByOthers checks whether any other provider having the same
+// action as the provider at index i lists the domain
+func listedByOthers(results []generator.ProviderResult, domains []map[string]struct{}, i int, domain string) bool {
+	for j := range results {
+		if j == i || results[j].Provider.Action != results[i].Provider.Action {
+			continue
+		}
+
+		if _, ok := domains[j][domain]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+)
+
+func TestAnalyze(t *testing.T) {
+	results, err := generator.CollectEntries("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "a.example.com\nb.example.com\nc.example.com",
+			MinMatches: 1,
+			Name:       "Feed A",
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "c.example.com\nd.example.com",
+			MinMatches: 1,
+			Name:       "Feed B",
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionWhitelist,
+			Content:    "b.example.com",
+			MinMatches: 1,
+			Name:       "Whitelist",
+			Type:       "domain-list",
+		},
+	})
+	require.NoError(t, err)
+
+	r := Analyze(results)
+
+	assert.Equal(t, 3, r.Entries)
+	require.Len(t, r.Providers, 3)
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, r.Providers[0].UniqueDomains)
+	assert.Equal(t, 1, r.Providers[0].SolelyResponsible)
+	assert.Equal(t, -1, r.Providers[0].RemovalDelta)
+	assert.Equal(t, map[int]int{2: -1}, r.Providers[0].MinMatchesDelta)
+
+	assert.Equal(t, []string{"d.example.com"}, r.Providers[1].UniqueDomains)
+	assert.Equal(t, 1, r.Providers[1].SolelyResponsible)
+	assert.Equal(t, -1, r.Providers[1].RemovalDelta)
+	assert.Equal(t, map[int]int{2: -1}, r.Providers[1].MinMatchesDelta)
+
+	assert.Equal(t, 1, r.Providers[2].RemovalDelta)
+	assert.Nil(t, r.Providers[2].MinMatchesDelta)
+
+	assert.InDelta(t, 1.0, r.Jaccard[0][0], 0.001)
+	assert.InDelta(t, 0.25, r.Jaccard[0][1], 0.001)
+	assert.InDelta(t, 0.25, r.Jaccard[1][0], 0.001)
+	assert.True(t, math.IsNaN(r.Jaccard[0][2]), "blacklist compared to whitelist")
+	assert.True(t, math.IsNaN(r.Jaccard[2][1]), "whitelist compared to blacklist")
+	assert.InDelta(t, 1.0, r.Jaccard[2][2], 0.001)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, r.Write(buf, true))
+	assert.Contains(t, buf.String(), "Blacklist entries with all providers: 3")
+	assert.Contains(t, buf.String(), "Domains unique to Feed B:\nd.example.com\n")
+	assert.Regexp(t, `#3 Whitelist\s+-\s+-\s+1\.000`, buf.String())
+}
+
+func TestAnalyzeSkipsMinMatchesWhenScoring(t *testing.T) {
+	results, err := generator.CollectEntries("testing", []config.ProviderDefinition{
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "a.example.com\nb.example.com",
+			Name:    "Feed A",
+			Type:    "domain-list",
+			Weight:  1,
+		},
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "b.example.com",
+			Name:    "Feed B",
+			Type:    "domain-list",
+			Weight:  1,
+		},
+	})
+	require.NoError(t, err)
+
+	r := Analyze(results, generator.WithScoreThreshold(2))
+
+	assert.Equal(t, 1, r.Entries)
+	require.Len(t, r.Providers, 2)
+
+	for _, p := range r.Providers {
+		assert.Nil(t, p.MinMatchesDelta)
+		assert.Equal(t, -1, p.RemovalDelta)
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, r.Write(buf, false))
+	assert.NotContains(t, buf.String(), "(current)")
+}
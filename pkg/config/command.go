@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+type (
+	// commandOutput streams the stdout of a running command and reports
+	// a failed command as read error instead of a regular EOF
+	commandOutput struct {
+		cancel context.CancelFunc
+		cmd    *exec.Cmd
+		ctx    context.Context
+		stderr *bytes.Buffer
+		stdout io.ReadCloser
+
+		waitErr  error
+		waitOnce sync.Once
+	}
+)
+
+func (c *CommandSource) run(parent context.Context) (io.ReadCloser, error) {
+	if c.Exec == "" {
+		return nil, fmt.Errorf("no executable specified")
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, c.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+
+	cmd := exec.CommandContext(ctx, c.Exec, c.Args...) //#nosec:G204 // Intended to run the configured command
+	cmd.Dir = c.Dir
+	cmd.Env = os.Environ()
+	for k, v := range c.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	out := &commandOutput{
+		cancel: cancel,
+		cmd:    cmd,
+		ctx:    ctx,
+		stderr: new(bytes.Buffer),
+	}
+	cmd.Stderr = out.stderr
+
+	var err error
+	if out.stdout, err = cmd.StdoutPipe(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("starting command: %w", err)
+	}
+
+	return out, nil
+}
+
+// Close stops the command in case it is still running and releases
+// its resources
+func (c *commandOutput) Close() error {
+	c.cancel()
+	_ = c.wait()
+	return nil
+}
+
+func (c *commandOutput) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if werr := c.wait(); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err //nolint:wrapcheck // Must not wrap io.EOF
+}
+
+func (c *commandOutput) wait() error {
+	c.waitOnce.Do(func() {
+		err := c.cmd.Wait()
+
+		switch {
+		case errors.Is(c.ctx.Err(), context.DeadlineExceeded):
+			c.waitErr = fmt.Errorf("command timed out: %w", c.ctx.Err())
+
+		case err != nil:
+			c.waitErr = fmt.Errorf("command failed: %w (stderr: %q)", err, strings.TrimSpace(c.stderr.String()))
+		}
+	})
+
+	return c.waitErr
+}
@@ -2,17 +2,15 @@
 package config
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"slices"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	korvike "github.com/Luzifer/korvike/functions"
-	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
 	"github.com/Luzifer/named-blacklist/pkg/helpers"
@@ -26,10 +24,29 @@ const (
 
 ; Blacklist entries
 {{ range .blacklist -}}
-{{ to_punycode .Domain }} CNAME . ; {{ .Comments }}
+{{ $domain := to_punycode .Domain -}}
+{{ if not .Redirect -}}
+{{ $domain }} CNAME . ; {{ .Comments }}
+{{ else if .Redirect.CNAME -}}
+{{ $domain }} CNAME {{ .Redirect.CNAME }}. ; {{ .Comments }}
+{{ else -}}
+{{ range .Redirect.A }}{{ $domain }} A {{ . }}
+{{ end -}}
+{{ range .Redirect.AAAA }}{{ $domain }} AAAA {{ . }}
+{{ end -}}
+{{ end -}}
 {{ end }}`
 )
 
+// List of built-in domain transforms
+const (
+	// DomainTransformStripWWW removes a leading "www." label
+	DomainTransformStripWWW DomainTransform = "strip_www"
+	// DomainTransformETLDPlusOne reduces the domain to the registrable
+	// domain (public suffix plus one label)
+	DomainTransformETLDPlusOne DomainTransform = "etld_plus_one"
+)
+
 const (
 	// ProviderActionBlacklist defines all domain results should be blocked
 	ProviderActionBlacklist ProviderAction = "blacklist"
@@ -37,148 +54,354 @@ const (
 	ProviderActionWhitelist ProviderAction = "whitelist"
 )
 
+// List of built-in provider types
+const (
+	ProviderTypeAdblockPlus ProviderType = "adblock-plus"
+	ProviderTypeCSV         ProviderType = "csv"
+	ProviderTypeDomainList  ProviderType = "domain-list"
+	ProviderTypeHostsFile   ProviderType = "hosts-file"
+	ProviderTypeJSON        ProviderType = "json"
+	ProviderTypeManual      ProviderType = "manual"
+	ProviderTypeURLList     ProviderType = "url-list"
+)
+
 type (
+	// CommandSource describes a local command whose output is used as
+	// content of the list
+	CommandSource struct {
+		// Exec is the executable to run, it is not passed through a shell
+		Exec string `yaml:"exec"`
+		// Args are passed to the executable as arguments
+		Args []string `yaml:"args"`
+		// Dir is the working directory of the command, defaults to the
+		// working directory of named-blacklist
+		Dir string `yaml:"dir"`
+		// Env contains additional environment variables, the environment
+		// of named-blacklist is inherited
+		Env map[string]string `yaml:"env"`
+		// Timeout limits the runtime of the command, zero disables the
+		// limit
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	// DomainFilter selects the entries of a provider by their
+	// (normalized) domain
+	DomainFilter struct {
+		// Include keeps only domains matching at least one of the rules,
+		// when empty all domains are kept
+		Include DomainRules `yaml:"include"`
+		// Exclude drops domains matching any of the rules
+		Exclude DomainRules `yaml:"exclude"`
+		// MaxLabels drops domains having more labels, zero disables the
+		// limit
+		MaxLabels int `yaml:"max_labels"`
+	}
+
+	// DomainRules match domains by suffix, regular expression or TLD
+	DomainRules struct {
+		// Regex contains expressions matched against the domain in its
+		// canonical (punycode) form
+		Regex []string `yaml:"regex"`
+		// Suffix matches the domain itself and all of its subdomains
+		Suffix []string `yaml:"suffix"`
+		// TLD matches all domains within the top level domain
+		TLD []string `yaml:"tld"`
+	}
+
+	// DomainTransform modifies the domain of every entry of a provider
+	DomainTransform string
+
+	// FetchOptions limit the load put onto the servers hosting the lists
+	FetchOptions struct {
+		// Concurrency limits the number of providers executed at the
+		// same time, zero executes all providers at once
+		Concurrency int `yaml:"concurrency"`
+		// HostConcurrency limits the number of concurrent requests sent
+		// to the same host, zero disables the limit
+		HostConcurrency int `yaml:"host_concurrency"`
+		// HostRateLimit limits the number of requests per second sent to
+		// the same host, zero disables the limit
+		HostRateLimit float64 `yaml:"host_rate_limit"`
+	}
+
 	// File represents the format the configuration file is expected in
 	File struct {
+		Fetch FetchOptions `yaml:"fetch"`
+
+		HTTP HTTPOptions `yaml:"http"`
+
+		// Include lists further config files (paths or globs relative to
+		// this file) to read providers and unset keys from. Only used
+		// while loading the file, the result is merged into the other
+		// fields.
+		Include []string `yaml:"include"`
+
+		Normalize NormalizeOptions `yaml:"normalize"`
+
+		Outputs []OutputDefinition `yaml:"outputs"`
+
+		// ProviderDefaults contains values for all providers defined in
+		// the same file not setting them. Only used while loading the
+		// file, the defaults are applied to the Providers.
+		ProviderDefaults ProviderDefinition `yaml:"provider_defaults"`
+
 		Providers []ProviderDefinition `yaml:"providers"`
 
+		// Redirects define named targets blocked domains resolve to, they
+		// are selected through the redirect of the providers listing the
+		// domain or the TagRedirects
+		Redirects map[string]Redirect `yaml:"redirects"`
+
+		// ScoreThreshold switches from min_matches to weighted scoring
+		// when set: a domain is included when the sum of the weights of
+		// the providers listing it reaches the threshold
+		ScoreThreshold float64 `yaml:"score_threshold"`
+
+		State StateConfig `yaml:"state"`
+
+		// TagRedirects maps tags to the name of the redirect to use for
+		// domains having the tag and no provider redirect
+		TagRedirects map[string]string `yaml:"tag_redirects"`
+
+		Template         string             `yaml:"template"`
+		CompiledTemplate *template.Template `yaml:"-"`
+
+		// Files contains the paths of all files read while loading the
+		// config (the file itself and its includes)
+		Files []string `yaml:"-"`
+
+		// secrets contains the values taken from the environment or
+		// secret files to be redacted from messages
+		secrets []string
+	}
+
+	// ManualEntry is a domain maintained directly within the config,
+	// optionally expiring at a given time
+	ManualEntry struct {
+		Domain string `yaml:"domain"`
+		// Expires is the time after which the entry is dropped, when
+		// zero the entry does not expire
+		Expires time.Time `yaml:"expires"`
+		// Reason and Ticket document why the entry was added
+		Reason string `yaml:"reason"`
+		Ticket string `yaml:"ticket"`
+	}
+
+	// NormalizeOptions control how domains are converted into their
+	// canonical form before being compared
+	NormalizeOptions struct {
+		// StripWWW removes a leading "www." label from all domains
+		StripWWW bool `yaml:"strip_www"`
+	}
+
+	// OutputDefinition describes a file to render (a subset of) the
+	// blacklist into
+	OutputDefinition struct {
+		// File to write the rendered template to, empty or `-` writes to
+		// stdout
+		File string `yaml:"file"`
+		// Tags limits the output to entries having at least one of the
+		// given tags, when empty all entries are used
+		Tags []string `yaml:"tags"`
+		// ExcludeTags removes entries having any of the given tags
+		ExcludeTags []string `yaml:"exclude_tags"`
+		// ScoreThreshold removes entries having a lower score, it is
+		// applied on top of the global selection and therefore can only
+		// remove further entries, never add ones dropped by the global
+		// min_matches / score_threshold
+		ScoreThreshold float64 `yaml:"score_threshold"`
+
+		// Template overrides the global template for this output
 		Template         string             `yaml:"template"`
 		CompiledTemplate *template.Template `yaml:"-"`
 	}
 
+	// Redirect describes what blocked domains resolve to instead of
+	// NXDOMAIN: either the given addresses (local-data) or a CNAME to a
+	// walled-garden host
+	Redirect struct {
+		A     []string `yaml:"a"`
+		AAAA  []string `yaml:"aaaa"`
+		CNAME string   `yaml:"cname"`
+	}
+
 	// ProviderAction defines the available actions to take with the provider
 	ProviderAction string
 
+	// FieldExtraction describes how to find the domain and additional
+	// information inside the records of structured (JSON / CSV) lists
+	FieldExtraction struct {
+		// Records is a path to the records inside a JSON document, when
+		// empty the document root is used (JSON only)
+		Records string `yaml:"records"`
+		// Domain is the path (JSON) or column name / index (CSV) of the
+		// field containing the domain
+		Domain string `yaml:"domain"`
+		// Comments lists fields to attach as comments to the entry
+		Comments []string `yaml:"comments"`
+		// Filters contains expressions like `threat_type == "malware"`
+		// which all need to match for a record to be used
+		Filters []string `yaml:"filters"`
+
+		// Header tells the CSV parser to take column names from the
+		// first non-comment row (CSV only)
+		Header bool `yaml:"header"`
+		// Separator defines the CSV field separator, defaults to `,`
+		// (CSV only)
+		Separator string `yaml:"separator"`
+	}
+
 	// ProviderDefinition describes a provider to use for gathering domains
 	ProviderDefinition struct {
-		Action     ProviderAction `yaml:"action"`
-		Content    string         `yaml:"content"`
-		File       string         `yaml:"file"`
-		MinMatches int            `yaml:"min_matches"`
-		Name       string         `yaml:"name"`
-		Type       ProviderType   `yaml:"type"`
-		URL        string         `yaml:"url"`
+		Action       ProviderAction    `yaml:"action"`
+		Command      *CommandSource    `yaml:"command"`
+		Content      string            `yaml:"content"`
+		Entries      []ManualEntry     `yaml:"entries"`
+		ExpiryWarn   time.Duration     `yaml:"expiry_warning"`
+		Extends      string            `yaml:"extends"`
+		Fields       FieldExtraction   `yaml:"fields"`
+		File         string            `yaml:"file"`
+		Filter       DomainFilter      `yaml:"filter"`
+		Group        string            `yaml:"group"`
+		HTTP         HTTPOptions       `yaml:"http"`
+		MinMatches   int               `yaml:"min_matches"`
+		MinURLs      int               `yaml:"min_urls"`
+		Name         string            `yaml:"name"`
+		Redirect     string            `yaml:"redirect"`
+		SinkholeOnly bool              `yaml:"sinkhole_only"`
+		Tags         []string          `yaml:"tags"`
+		Transforms   []DomainTransform `yaml:"transforms"`
+		Type         ProviderType      `yaml:"type"`
+		URL          string            `yaml:"url"`
+		Weight       float64           `yaml:"weight"`
+
+		// decodeErrors keeps the type errors of the definition as
+		// returning them would drop the whole definition from the list
+		decodeErrors []string
 	}
 
 	// ProviderType defines the type of provider to execute for this list
 	ProviderType string
+
+	// StateConfig configures the persistence of the domain history
+	// between runs
+	StateConfig struct {
+		// File to store the state in, when empty no state is kept
+		File string `yaml:"file"`
+		// GracePeriod keeps domains in the blacklist for the given
+		// duration after they dropped out of all lists
+		GracePeriod time.Duration `yaml:"grace_period"`
+	}
 )
 
-// LoadConfigFile reads the configuration and parses the template
+// LoadConfigFile reads the configuration and parses the template. All
+// problems found in the file are returned at once as ValidationErrors.
 func LoadConfigFile(filename string) (*File, error) {
-	f, err := os.Open(filename) //#nosec:G304 // Intended to load given config file
+	v := &validator{filename: filename, files: make(map[*yaml.Node]string)}
+
+	root, err := v.loadFile(filename, nil)
 	if err != nil {
-		return nil, fmt.Errorf("opening config file: %w", err)
+		return nil, fmt.Errorf("loading config file: %w", err)
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			logrus.WithError(err).Error("closing config file")
+	v.root = root
+
+	out := &File{Template: defaultTemplate, Files: v.loaded}
+	if err = root.Decode(out); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("parsing config file: %w", err)
 		}
-	}()
 
-	out := &File{Template: defaultTemplate}
-	if err = yaml.NewDecoder(f).Decode(out); err != nil {
-		return nil, fmt.Errorf("parsing config file: %w", err)
+		for _, msg := range typeErr.Errors {
+			v.addDecodeError(root, msg)
+		}
 	}
 
-	for _, p := range out.Providers {
-		if p.MinMatches < 1 {
-			return nil, fmt.Errorf("validating providers: provider %q has invalid min_matches %d", p.Name, p.MinMatches)
-		}
+	v.validateFile(out)
+
+	for name, r := range out.Redirects {
+		// Templates add the trailing dot to the CNAME
+		r.CNAME = strings.TrimSuffix(r.CNAME, ".")
+		out.Redirects[name] = r
+	}
+
+	for i, p := range out.Providers {
+		out.Providers[i].HTTP = out.HTTP.Merge(p.HTTP)
 	}
 
 	funcs := korvike.GetFunctionMap()
 	funcs["to_punycode"] = helpers.DomainToPunycode
+	funcs["has_tag"] = func(tags []string, want ...string) bool {
+		return slices.ContainsFunc(want, func(tag string) bool { return slices.Contains(tags, tag) })
+	}
 	funcs["join"] = strings.Join
 	funcs["sort"] = func(in []string) []string {
 		sort.Slice(in, func(i, j int) bool { return strings.ToLower(in[i]) < strings.ToLower(in[j]) })
 		return in
 	}
 
-	if out.CompiledTemplate, err = template.
-		New("configTemplate").
-		Funcs(funcs).
-		Parse(out.Template); err != nil {
-		return nil, fmt.Errorf("parsing template: %w", err)
-	}
+	out.CompiledTemplate = v.compileTemplate("configTemplate", out.Template, funcs, v.root, "template")
 
-	return out, nil
-}
+	if len(out.Outputs) == 0 {
+		// Keep the behavior of writing the global template to stdout
+		out.Outputs = []OutputDefinition{{}}
+	}
 
-// GetContent retrieves the content of the given list for parsing with
-// a provider
-func (p ProviderDefinition) GetContent(appVersion string) (io.ReadCloser, error) {
-	switch {
-	case p.Content != "":
-		return io.NopCloser(strings.NewReader(p.Content)), nil
-
-	case p.File != "":
-		f, err := os.Open(p.File)
-		if err != nil {
-			return nil, fmt.Errorf("opening file: %w", err)
+	for i := range out.Outputs {
+		if out.Outputs[i].Template == "" {
+			out.Outputs[i].CompiledTemplate = out.CompiledTemplate
+			continue
 		}
-		return f, nil
 
-	case p.URL != "":
-		return p.fetchURLContent(appVersion)
+		out.Outputs[i].CompiledTemplate = v.compileTemplate("outputTemplate", out.Outputs[i].Template, funcs,
+			v.node("outputs", i), "template")
+	}
 
-	default:
-		return nil, fmt.Errorf("neither file nor URL specified")
+	if len(v.errs) > 0 {
+		for i := range v.errs {
+			v.errs[i].Message = v.redact(v.errs[i].Message)
+		}
+
+		sort.SliceStable(v.errs, func(i, j int) bool {
+			if v.errs[i].File != v.errs[j].File {
+				return v.errs[i].File < v.errs[j].File
+			}
+			if v.errs[i].Line != v.errs[j].Line {
+				return v.errs[i].Line < v.errs[j].Line
+			}
+			return v.errs[i].Column < v.errs[j].Column
+		})
+		return nil, v.errs
 	}
+
+	out.secrets = v.secrets
+
+	return out, nil
 }
 
 // UnmarshalYAML applies config defaults while still allowing validation to
 // distinguish between omitted and explicitly configured values.
 func (p *ProviderDefinition) UnmarshalYAML(node *yaml.Node) error {
-	raw := struct {
-		Action     ProviderAction `yaml:"action"`
-		Content    string         `yaml:"content"`
-		File       string         `yaml:"file"`
-		MinMatches *int           `yaml:"min_matches"`
-		Name       string         `yaml:"name"`
-		Type       ProviderType   `yaml:"type"`
-		URL        string         `yaml:"url"`
-	}{
-		MinMatches: nil,
-	}
-
-	if err := node.Decode(&raw); err != nil {
-		return fmt.Errorf("decoding yaml: %w", err)
-	}
+	// Use a type without the UnmarshalYAML method to prevent recursion
+	type rawProviderDefinition ProviderDefinition
 
-	*p = ProviderDefinition{
-		Action:     raw.Action,
-		Content:    raw.Content,
-		File:       raw.File,
+	raw := rawProviderDefinition{
 		MinMatches: 1,
-		Name:       raw.Name,
-		Type:       raw.Type,
-		URL:        raw.URL,
-	}
-	if raw.MinMatches != nil {
-		p.MinMatches = *raw.MinMatches
+		Weight:     1,
 	}
 
-	return nil
-}
+	err := node.Decode(&raw)
 
-func (p ProviderDefinition) fetchURLContent(version string) (io.ReadCloser, error) {
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, p.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		raw.decodeErrors = typeErr.Errors
+		err = nil
 	}
 
-	req.Header.Set("User-Agent", fmt.Sprintf("named-blacklist %s (https://github.com/Luzifer/named-blacklist)", version))
-
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("executing request: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		_ = resp.Body.Close()
-		return nil, fmt.Errorf("unexected status %d", resp.StatusCode)
+		return fmt.Errorf("decoding yaml: %w", err)
 	}
 
-	return resp.Body, nil
+	*p = ProviderDefinition(raw)
+	return nil
 }
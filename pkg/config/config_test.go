@@ -1,10 +1,17 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -25,6 +32,55 @@ providers:
 	require.NoError(t, err)
 	require.Len(t, cfg.Providers, 1)
 	assert.Equal(t, 1, cfg.Providers[0].MinMatches)
+	assert.InDelta(t, 1.0, cfg.Providers[0].Weight, 0)
+}
+
+func TestLoadConfigFileManualEntries(t *testing.T) {
+	conf := writeConfigFile(t, `
+providers:
+  - name: Incident Response
+    action: blacklist
+    type: manual
+    expiry_warning: 24h
+    entries:
+      - domain: evil.example.com
+        expires: 2026-11-01
+        reason: Phishing campaign
+        ticket: INC-1234
+      - domain: malware.example.com
+        expires: 2026-11-01T12:30:00+02:00
+      - domain: permanent.example.com
+`)
+
+	cfg, err := LoadConfigFile(conf)
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers[0].Entries, 3)
+
+	assert.Equal(t, 24*time.Hour, cfg.Providers[0].ExpiryWarn)
+	assert.Equal(t, ManualEntry{
+		Domain:  "evil.example.com",
+		Expires: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		Reason:  "Phishing campaign",
+		Ticket:  "INC-1234",
+	}, cfg.Providers[0].Entries[0])
+	assert.True(t, time.Date(2026, 11, 1, 10, 30, 0, 0, time.UTC).Equal(cfg.Providers[0].Entries[1].Expires))
+	assert.True(t, cfg.Providers[0].Entries[2].Expires.IsZero())
+}
+
+func TestLoadConfigFileRejectsInvalidWeight(t *testing.T) {
+	conf := writeConfigFile(t, `
+providers:
+  - name: Invalid Provider
+    content: |
+      example.com
+    action: blacklist
+    type: domain-list
+    weight: 0
+`)
+
+	_, err := LoadConfigFile(conf)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `provider "Invalid Provider" has invalid weight`)
 }
 
 func TestLoadConfigFileRejectsInvalidMinMatches(t *testing.T) {
@@ -56,6 +112,458 @@ providers:
 	}
 }
 
+func TestLoadConfigFileReportsAllProblems(t *testing.T) {
+	conf := writeConfigFile(t, `
+http:
+  tiemout: 5s
+providers:
+  - name: Feed
+    action: blacklst
+    type: domain-list
+    content: example.com
+    url: https://example.com/list.txt
+  - name: Feed
+    action: blacklist
+    type: domain-lst
+    min_matches: many
+    file: list.txt
+  - action: whitelist
+    type: manual
+    file: list.txt
+template: "{{ range .blacklist }}{{ .Domian }}{{ end }}"
+`)
+
+	_, err := LoadConfigFile(conf)
+	require.Error(t, err)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, fmt.Sprintf("%d:%d %s", p.Line, p.Column, p.Message))
+	}
+
+	assert.Equal(t, []string{
+		`3:3 unknown field "tiemout"`,
+		`6:13 provider "Feed" has invalid action "blacklst"`,
+		`9:10 provider "Feed" has multiple sources (content, url)`,
+		`10:11 provider "Feed" is already defined in line 5`,
+		`12:11 provider "Feed" has unknown type "domain-lst"`,
+		"13:18 cannot unmarshal !!str `many` into int",
+		`15:5 provider #3 has no name`,
+		`17:11 provider #3 of type manual requires entries as source`,
+		`18:11 rendering template with sample data: template: configTemplate:1:25: executing "configTemplate" at <.Domian>: can't evaluate field Domian in type config.sampleEntry`,
+	}, got)
+}
+
+func TestLoadConfigFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "catalog"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "catalog", "feeds.yaml"), []byte(`
+providers:
+  - name: Feed A
+    action: blacklist
+    type: domain-list
+    url: https://example.com/a.txt
+    min_matches: 2
+    tags: [ads]
+  - name: Feed B
+    action: blacklist
+    type: hosts-file
+    url: https://example.com/b.txt
+template: "{{ range .blacklist }}{{ .Domain }}{{ end }}"
+`), 0o600))
+
+	conf := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(conf, []byte(`
+include:
+  - catalog/*.yaml
+provider_defaults:
+  weight: 2
+providers:
+  - extends: Feed A
+    min_matches: 1
+  - name: Feed B
+    action: whitelist
+    type: domain-list
+    content: example.com
+  - name: Feed A Mirror
+    extends: Feed A
+    url: https://mirror.example.com/a.txt
+  - name: Local
+    action: blacklist
+    type: domain-list
+    file: local.txt
+`), 0o600))
+
+	cfg, err := LoadConfigFile(conf)
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 4)
+
+	assert.Equal(t, "Feed A", cfg.Providers[0].Name)
+	assert.Equal(t, "https://example.com/a.txt", cfg.Providers[0].URL)
+	assert.Equal(t, 1, cfg.Providers[0].MinMatches)
+	assert.Equal(t, []string{"ads"}, cfg.Providers[0].Tags)
+	assert.InDelta(t, 2.0, cfg.Providers[0].Weight, 0)
+
+	assert.Equal(t, "Feed B", cfg.Providers[1].Name)
+	assert.Equal(t, ProviderActionWhitelist, cfg.Providers[1].Action)
+	assert.Empty(t, cfg.Providers[1].URL)
+
+	assert.Equal(t, "Feed A Mirror", cfg.Providers[2].Name)
+	assert.Equal(t, "https://mirror.example.com/a.txt", cfg.Providers[2].URL)
+	// Extends the overridden definition
+	assert.Equal(t, 1, cfg.Providers[2].MinMatches)
+	assert.Equal(t, []string{"ads"}, cfg.Providers[2].Tags)
+
+	assert.Equal(t, "Local", cfg.Providers[3].Name)
+	assert.InDelta(t, 2.0, cfg.Providers[3].Weight, 0)
+
+	// Keys not set in the including file are taken from the includes
+	assert.Equal(t, "{{ range .blacklist }}{{ .Domain }}{{ end }}", cfg.Template)
+
+	assert.Equal(t, []string{conf, filepath.Join(dir, "catalog", "feeds.yaml")}, cfg.Files)
+}
+
+func TestLoadConfigFileIncludeProblems(t *testing.T) {
+	dir := t.TempDir()
+
+	catalog := filepath.Join(dir, "catalog.yaml")
+	require.NoError(t, os.WriteFile(catalog, []byte(`
+score_threshold: high
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-lst
+    url: https://example.com/a.txt
+    min_matches: abc
+`), 0o600))
+
+	conf := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(conf, []byte(`
+include: catalog.yaml
+providers:
+  - name: Local
+    extends: Unknown
+`), 0o600))
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Error())
+	}
+
+	assert.Equal(t, []string{
+		catalog + ":2:18: cannot unmarshal !!str `high` into float64",
+		catalog + `:6:11: provider "Feed" has unknown type "domain-lst"`,
+		catalog + ":8:18: cannot unmarshal !!str `abc` into int",
+		conf + `:4:5: provider "Local" has invalid action ""`,
+		conf + `:4:5: provider "Local" has unknown type ""`,
+		conf + `:4:5: provider "Local" has no source (command, content, entries, file or url)`,
+		conf + `:5:14: provider "Unknown" to extend is not defined`,
+	}, got)
+
+	require.NoError(t, os.WriteFile(catalog, []byte("include: config.yaml\n"), 0o600))
+
+	_, err = LoadConfigFile(conf)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle")
+}
+
+func TestLoadConfigFileExpandsReferences(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api-key"), []byte("s3cr3t-key\n"), 0o600))
+
+	t.Setenv("NB_TEST_HOST", "feeds.example.com")
+	t.Setenv("NB_TEST_EMPTY", "")
+
+	conf := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(conf, []byte(`
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-list
+    url: "https://${NB_TEST_HOST}/list.txt?key=${file:api-key}"
+    min_matches: ${NB_TEST_MIN_MATCHES:-2}
+    tags: ["${NB_TEST_EMPTY:-default}", "$${NB_TEST_HOST}"]
+`), 0o600))
+
+	cfg, err := LoadConfigFile(conf)
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 1)
+
+	assert.Equal(t, "https://feeds.example.com/list.txt?key=s3cr3t-key", cfg.Providers[0].URL)
+	assert.Equal(t, 2, cfg.Providers[0].MinMatches)
+	assert.Equal(t, []string{"default", "${NB_TEST_HOST}"}, cfg.Providers[0].Tags)
+}
+
+func TestLoadConfigFileRedactsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "type"), []byte("s3cr3t-type"), 0o600))
+
+	t.Setenv("NB_TEST_ACTION", "s3cr3t-action")
+
+	conf := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(conf, []byte(`
+providers:
+  - name: Feed
+    action: ${NB_TEST_ACTION}
+    type: ${file:type}
+    content: ${NB_TEST_UNSET}
+`), 0o600))
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+
+	assert.Equal(t, []string{
+		`provider "Feed" has no source (command, content, entries, file or url)`,
+		`provider "Feed" has invalid action "***"`,
+		`provider "Feed" has unknown type "***"`,
+		`environment variable "NB_TEST_UNSET" is not set`,
+	}, got)
+}
+
+func TestGetSourcesFromFileGlobAndDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"incident-2.txt": "b.example.com\n",
+		"incident-1.txt": "a.example.com\n",
+		"notes.md":       "not a list\n",
+		".swp":           "hidden\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "archive.txt"), 0o700))
+
+	for file, expected := range map[string][]string{
+		dir:                             {"incident-1.txt", "incident-2.txt", "notes.md"},
+		filepath.Join(dir, "*.txt"):     {"incident-1.txt", "incident-2.txt"},
+		filepath.Join(dir, "notes.md"):  {""},
+		filepath.Join(dir, "nothing-*"): {},
+	} {
+		sources, err := ProviderDefinition{File: file}.GetSources(SourceOptions{AppVersion: "testing"})
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, src := range sources {
+			if src.Name == "" {
+				names = append(names, "")
+				continue
+			}
+			names = append(names, filepath.Base(src.Name))
+		}
+		assert.Equal(t, expected, names, file)
+	}
+}
+
+func TestGetContentFromCommand(t *testing.T) {
+	p := ProviderDefinition{
+		Command: &CommandSource{
+			Exec:    "sh",
+			Args:    []string{"-c", `echo "a.example.com"; echo "$LIST_ENTRY"; pwd`},
+			Dir:     t.TempDir(),
+			Env:     map[string]string{"LIST_ENTRY": "b.example.com"},
+			Timeout: time.Second,
+		},
+	}
+
+	sources, err := p.GetSources(SourceOptions{AppVersion: "testing"})
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+
+	r, err := sources[0].Open()
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, "a.example.com\nb.example.com\n"+p.Command.Dir+"\n", string(content))
+}
+
+func TestGetContentFromCommandReportsFailures(t *testing.T) {
+	for name, cmd := range map[string]*CommandSource{
+		"Exit Code": {Exec: "sh", Args: []string{"-c", "echo a.example.com; echo broken >&2; exit 1"}},
+		"Timeout":   {Exec: "sleep", Args: []string{"5"}, Timeout: 100 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sources, err := ProviderDefinition{Command: cmd}.GetSources(SourceOptions{AppVersion: "testing"})
+			require.NoError(t, err)
+
+			r, err := sources[0].Open()
+			require.NoError(t, err)
+
+			_, err = io.ReadAll(r)
+			require.Error(t, err)
+			require.NoError(t, r.Close())
+		})
+	}
+}
+
+func TestGetSourcesFromURLWithHTTPOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.Header.Get("X-Api-Key") != "from-env" || r.Header.Get("X-Site") != "office" || user != "feed" || pass != "from-file" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "a.example.com")
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	passFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passFile, []byte("from-file\n"), 0o600))
+
+	t.Setenv("TEST_FEED_API_KEY", "from-env")
+
+	conf := writeConfigFile(t, fmt.Sprintf(`
+http:
+  headers:
+    X-Api-Key:
+      env: TEST_FEED_API_KEY
+    X-Site: global
+  tls:
+    ca_file: %s
+
+providers:
+  - name: Paid Feed
+    url: %s
+    action: blacklist
+    type: domain-list
+    http:
+      headers:
+        X-Site: office
+      basic_auth:
+        username: feed
+        password:
+          file: %s
+`, caFile, srv.URL, passFile))
+
+	cfg, err := LoadConfigFile(conf)
+	require.NoError(t, err)
+
+	sources, err := cfg.Providers[0].GetSources(SourceOptions{AppVersion: "testing"})
+	require.NoError(t, err)
+
+	r, err := sources[0].Open()
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, "a.example.com\n", string(content))
+}
+
+func TestHTTPOptionsReuseClients(t *testing.T) {
+	var (
+		base    = &http.Client{}
+		clients = NewHTTPClients()
+	)
+
+	client, err := HTTPOptions{}.client(base, clients)
+	require.NoError(t, err)
+	assert.Same(t, base, client)
+
+	client, err = HTTPOptions{Proxy: proxyNone, Timeout: time.Minute}.client(base, clients)
+	require.NoError(t, err)
+	assert.NotSame(t, base, client)
+
+	again, err := HTTPOptions{Proxy: proxyNone, Timeout: time.Minute}.client(base, clients)
+	require.NoError(t, err)
+	assert.Same(t, client, again)
+
+	other, err := HTTPOptions{Proxy: proxyNone, Timeout: time.Hour}.client(base, clients)
+	require.NoError(t, err)
+	assert.NotSame(t, client, other)
+
+	// Without clients to reuse every call creates a new client
+	unshared, err := HTTPOptions{Proxy: proxyNone, Timeout: time.Minute}.client(base, nil)
+	require.NoError(t, err)
+	assert.NotSame(t, client, unshared)
+}
+
+func TestHTTPOptionsRecreateClientOnTLSChange(t *testing.T) {
+	var (
+		caFile  = filepath.Join(t.TempDir(), "ca.pem")
+		clients = NewHTTPClients()
+		opts    = HTTPOptions{TLS: TLSOptions{CAFile: caFile}}
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	writeCA := func(modTime time.Time) {
+		cert := srv.Certificate()
+		require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600))
+		require.NoError(t, os.Chtimes(caFile, modTime, modTime))
+	}
+
+	writeCA(time.Now().Add(-time.Hour))
+
+	client, err := opts.client(http.DefaultClient, clients)
+	require.NoError(t, err)
+
+	again, err := opts.client(http.DefaultClient, clients)
+	require.NoError(t, err)
+	assert.Same(t, client, again)
+
+	// A rotated CA file is read again
+	writeCA(time.Now())
+
+	rotated, err := opts.client(http.DefaultClient, clients)
+	require.NoError(t, err)
+	assert.NotSame(t, client, rotated)
+	assert.Len(t, clients.clients, 1)
+}
+
+func TestGetSourcesUsesSourceOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.UserAgent(), "named-blacklist v1.2.3")
+		_, _ = fmt.Fprintln(w, "a.example.com")
+	}))
+	t.Cleanup(srv.Close)
+
+	p := ProviderDefinition{URL: srv.URL}
+
+	// The default client does not trust the test certificate
+	sources, err := p.GetSources(SourceOptions{AppVersion: "v1.2.3", HTTPClient: srv.Client()})
+	require.NoError(t, err)
+
+	r, err := sources[0].Open()
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sources, err = p.GetSources(SourceOptions{Context: ctx, HTTPClient: srv.Client()})
+	require.NoError(t, err)
+
+	_, err = sources[0].Open()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
 func writeConfigFile(t *testing.T, content string) string {
 	t.Helper()
 
@@ -66,3 +574,206 @@ func writeConfigFile(t *testing.T, content string) string {
 
 	return filename
 }
+
+func TestLoadConfigFileRejectsInvalidFetchOptions(t *testing.T) {
+	conf := writeConfigFile(t, `
+fetch:
+  concurrency: -1
+  host_rate_limit: -0.5
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-list
+    content: example.com
+`)
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "invalid fetch concurrency -1", problems[0].Message)
+	assert.Equal(t, 3, problems[0].Line)
+	assert.Equal(t, "invalid fetch host_rate_limit -0.5", problems[1].Message)
+}
+
+func TestLoadConfigFileRejectsInvalidFilters(t *testing.T) {
+	conf := writeConfigFile(t, `
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-list
+    content: example.com
+    filter:
+      include:
+        tld: [cn, com.cn]
+      exclude:
+        regex: ['(']
+        suffix: [googlevideo.com]
+      max_labels: -1
+    transforms: [strip_www, lowercase]
+`)
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+	require.Len(t, problems, 4)
+	assert.Equal(t, `provider "Feed" has invalid include tld "com.cn"`, problems[0].Message)
+	assert.Contains(t, problems[1].Message, `provider "Feed" has invalid exclude regex "("`)
+	assert.Equal(t, 11, problems[1].Line)
+	assert.Equal(t, `provider "Feed" has invalid filter max_labels -1`, problems[2].Message)
+	assert.Equal(t, `provider "Feed" has unknown transform "lowercase"`, problems[3].Message)
+}
+
+func TestLoadConfigFileRejectsInvalidFieldsAndEntries(t *testing.T) {
+	conf := writeConfigFile(t, `
+providers:
+  - name: JSON
+    action: blacklist
+    type: json
+    content: '[]'
+    fields:
+      filters:
+        - threat_type == "malware"
+        - threat_type =~ (
+  - name: CSV
+    action: blacklist
+    type: csv
+    content: example.com
+  - name: Manual
+    action: blacklist
+    type: manual
+    entries:
+      - domain: valid.example.com
+      - domain: not a domain
+`)
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+	require.Len(t, problems, 4)
+
+	assert.Equal(t, `provider "JSON" of type json requires fields.domain`, problems[0].Message)
+	assert.Equal(t, 8, problems[0].Line)
+	assert.Contains(t, problems[1].Message, `provider "JSON" has invalid fields filter: compiling regex of filter "threat_type =~ ("`)
+	assert.Equal(t, 10, problems[1].Line)
+	assert.Equal(t, 11, problems[1].Column)
+	assert.Equal(t, `provider "CSV" of type csv requires fields.domain`, problems[2].Message)
+	assert.Equal(t, 11, problems[2].Line)
+	assert.Equal(t, `provider "Manual" has invalid domain "not a domain" in entry 2`, problems[3].Message)
+	assert.Equal(t, 20, problems[3].Line)
+	assert.Equal(t, 17, problems[3].Column)
+}
+
+func TestLoadConfigFileRejectsInvalidSeparator(t *testing.T) {
+	conf := writeConfigFile(t, `
+providers:
+  - name: Tab
+    action: blacklist
+    type: csv
+    content: example.com
+    fields: {domain: "0", separator: "\t"}
+  - name: Semicolon
+    action: blacklist
+    type: csv
+    content: example.com
+    fields: {domain: "0", separator: ";"}
+  - name: Multiple
+    action: blacklist
+    type: csv
+    content: example.com
+    fields: {domain: "0", separator: ";;"}
+  - name: Quote
+    action: blacklist
+    type: csv
+    content: example.com
+    fields: {domain: "0", separator: '"'}
+`)
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+	require.Len(t, problems, 2)
+	assert.Equal(t, `provider "Multiple" has invalid fields separator ";;", a single character is required`, problems[0].Message)
+	assert.Equal(t, 17, problems[0].Line)
+	assert.Equal(t, `provider "Quote" has invalid fields separator "\"", a single character is required`, problems[1].Message)
+}
+
+func TestLoadConfigFileRedirects(t *testing.T) {
+	conf := writeConfigFile(t, `
+redirects:
+  phishing:
+    cname: warning.example.net.
+  local:
+    a: [192.0.2.1]
+    aaaa: [2001:db8::1]
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-list
+    content: example.com
+    redirect: local
+tag_redirects:
+  phishing: phishing
+`)
+
+	cfg, err := LoadConfigFile(conf)
+	require.NoError(t, err)
+	assert.Equal(t, "warning.example.net", cfg.Redirects["phishing"].CNAME)
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.CompiledTemplate.Execute(&buf, map[string]any{
+		"blacklist": []sampleEntry{
+			sample("blocked.example.com", nil),
+			sample("phishing.example.com", &Redirect{CNAME: cfg.Redirects["phishing"].CNAME}),
+			sample("local.example.com", &Redirect{A: cfg.Redirects["local"].A, AAAA: cfg.Redirects["local"].AAAA}),
+		},
+	}))
+
+	assert.Contains(t, buf.String(), "; Blacklist entries\n"+
+		"blocked.example.com CNAME . ; [\"Sample Provider\"]\n"+
+		"phishing.example.com CNAME warning.example.net. ; [\"Sample Provider\"]\n"+
+		"local.example.com A 192.0.2.1\n"+
+		"local.example.com AAAA 2001:db8::1\n")
+}
+
+func TestLoadConfigFileRejectsInvalidRedirects(t *testing.T) {
+	conf := writeConfigFile(t, `
+redirects:
+  both:
+    cname: warning.example.net
+    a: [192.0.2.1]
+  empty: {}
+  wrong-family:
+    a: [2001:db8::1]
+providers:
+  - name: Feed
+    action: blacklist
+    type: domain-list
+    content: example.com
+    redirect: missing
+tag_redirects:
+  phishing: unknown
+`)
+
+	_, err := LoadConfigFile(conf)
+
+	var problems ValidationErrors
+	require.ErrorAs(t, err, &problems)
+
+	var messages []string
+	for _, p := range problems {
+		messages = append(messages, p.Message)
+	}
+
+	assert.ElementsMatch(t, []string{
+		`redirect "both" must not have a cname and addresses`,
+		`redirect "empty" has neither a cname nor addresses`,
+		`redirect "wrong-family" has invalid IPv4 address "2001:db8::1"`,
+		`provider "Feed" has unknown redirect "missing"`,
+		`tag "phishing" has unknown redirect "unknown"`,
+	}, messages)
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// minRedactLength prevents short values (i.e. numbers) from being
+	// redacted everywhere in messages
+	minRedactLength  = 4
+	redacted         = "***"
+	secretFilePrefix = "file:"
+)
+
+var (
+	expansionRef = regexp.MustCompile(`\$(\$?)\{([^}]*)\}`)
+	variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// expandValues replaces `${VAR}`, `${VAR:-default}` and `${file:path}`
+// references in all scalar values below the given node. Keys are not
+// expanded, `$${...}` is kept as literal `${...}`.
+func (v *validator) expandValues(node *yaml.Node, dir string) {
+	if node == nil {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			v.expandValues(node.Content[i], dir)
+		}
+
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, c := range node.Content {
+			v.expandValues(c, dir)
+		}
+
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+
+		node.Value = expansionRef.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			m := expansionRef.FindStringSubmatch(ref)
+			if m[1] != "" {
+				// Escaped reference
+				return ref[1:]
+			}
+
+			return v.resolveReference(node, m[2], dir)
+		})
+
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 && node.Tag == "!!str" {
+			// Let the decoder resolve the type of the expanded value to
+			// support references in non-string values
+			node.Tag = ""
+		}
+
+	default:
+		// Aliases point to nodes expanded at their definition
+	}
+}
+
+// Redact replaces all values taken from the environment or secret
+// files while loading the config within the message
+func (f File) Redact(msg string) string {
+	return redactSecrets(f.secrets, msg)
+}
+
+// redact replaces all values taken from the environment or secret
+// files within the message
+func (v *validator) redact(msg string) string {
+	return redactSecrets(v.secrets, msg)
+}
+
+func redactSecrets(secrets []string, msg string) string {
+	for _, s := range secrets {
+		if len(s) < minRedactLength {
+			continue
+		}
+		msg = strings.ReplaceAll(msg, s, redacted)
+	}
+
+	return msg
+}
+
+// resolveReference returns the value of a single reference and records
+// problems at the position of the node
+func (v *validator) resolveReference(node *yaml.Node, expr, dir string) string {
+	if path, ok := strings.CutPrefix(expr, secretFilePrefix); ok {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		data, err := os.ReadFile(path) //#nosec:G304 // Intended to read referenced secret
+		if err != nil {
+			v.add(node, "reading secret file %q: %s", path, err)
+			return ""
+		}
+
+		value := strings.TrimRight(string(data), "\r\n")
+		v.secrets = append(v.secrets, value)
+		return value
+	}
+
+	name, def, hasDefault := strings.Cut(expr, ":-")
+	if !variableName.MatchString(name) {
+		v.add(node, "invalid reference ${%s}", expr)
+		return ""
+	}
+
+	value, ok := os.LookupEnv(name)
+	switch {
+	case value == "" && hasDefault:
+		return def
+
+	case !ok:
+		v.add(node, "environment variable %q is not set", name)
+		return ""
+	}
+
+	v.secrets = append(v.secrets, value)
+	return value
+}
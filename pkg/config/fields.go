@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// List of operators available in field filter expressions
+const (
+	FieldFilterOpEqual    = "=="
+	FieldFilterOpNotEqual = "!="
+	FieldFilterOpMatch    = "=~"
+	FieldFilterOpNotMatch = "!~"
+
+	fieldFilterExpectGroups = 4
+)
+
+// FieldFilter represents a parsed expression of the Filters of a
+// FieldExtraction like `threat_type == "malware"`
+type FieldFilter struct {
+	Field string
+	Op    string
+	// Value is the unquoted value to compare the field with
+	Value string
+	// Regex is the compiled value for the match operators
+	Regex *regexp.Regexp
+}
+
+var fieldFilterMatcher = regexp.MustCompile(`^\s*([^\s=!~]+)\s*(==|!=|=~|!~)\s*(.*?)\s*$`)
+
+// ParseFieldFilter parses a single filter expression
+func ParseFieldFilter(expr string) (FieldFilter, error) {
+	groups := fieldFilterMatcher.FindStringSubmatch(expr)
+	if len(groups) != fieldFilterExpectGroups {
+		return FieldFilter{}, fmt.Errorf("invalid filter expression %q", expr)
+	}
+
+	f := FieldFilter{Field: groups[1], Op: groups[2], Value: groups[3]}
+
+	if strings.HasPrefix(f.Value, `"`) {
+		v, err := strconv.Unquote(f.Value)
+		if err != nil {
+			return FieldFilter{}, fmt.Errorf("unquoting value of filter %q: %w", expr, err)
+		}
+		f.Value = v
+	}
+
+	if f.Op == FieldFilterOpMatch || f.Op == FieldFilterOpNotMatch {
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return FieldFilter{}, fmt.Errorf("compiling regex of filter %q: %w", expr, err)
+		}
+		f.Regex = re
+	}
+
+	return f, nil
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const proxyNone = "none"
+
+type (
+	// BasicAuth contains credentials to use for HTTP basic auth
+	BasicAuth struct {
+		Username Secret `yaml:"username"`
+		Password Secret `yaml:"password"`
+	}
+
+	// HTTPClients keeps the clients created for custom HTTP options to
+	// reuse their transport (and its connections) between fetches. A
+	// client is created again when one of its TLS files changed. Use
+	// one instance per generator to not keep clients of base clients
+	// which are not used anymore.
+	HTTPClients struct {
+		clients map[httpClientKey]httpClient
+		lock    sync.Mutex
+	}
+
+	// HTTPOptions customizes the requests made to fetch URL sources
+	HTTPOptions struct {
+		// BasicAuth sets credentials for HTTP basic auth
+		BasicAuth *BasicAuth `yaml:"basic_auth"`
+		// Headers are added to the request (i.e. `Authorization`)
+		Headers map[string]Secret `yaml:"headers"`
+		// Proxy is the URL of the proxy to use, when empty the proxy is
+		// taken from the environment (HTTP_PROXY, ...), `none` disables
+		// the use of a proxy
+		Proxy string `yaml:"proxy"`
+		// Timeout limits the duration of the whole request
+		Timeout time.Duration `yaml:"timeout"`
+		// TLS configures certificate validation and client certificates
+		TLS TLSOptions `yaml:"tls"`
+	}
+
+	// HTTPStatusError is returned when fetching an URL source results in
+	// an unexpected status code
+	HTTPStatusError struct {
+		StatusCode int
+	}
+
+	// Secret is a configuration value which can either be given inline
+	// or be loaded from an environment variable or file to keep it out
+	// of the configuration file
+	Secret struct {
+		Value string `yaml:"value"`
+		Env   string `yaml:"env"`
+		File  string `yaml:"file"`
+	}
+
+	// httpClient is a client created for custom options together with
+	// the versions of the TLS files it was created from
+	httpClient struct {
+		client   *http.Client
+		tlsFiles [3]fileVersion
+	}
+
+	// httpClientKey identifies a client created for the options
+	httpClientKey struct {
+		base    *http.Client
+		proxy   string
+		timeout time.Duration
+		tls     TLSOptions
+	}
+
+	// fileVersion identifies the content of a file without reading it
+	fileVersion struct {
+		modTime int64
+		size    int64
+	}
+
+	// httpBody is the content of an URL source carrying the status code
+	// of the response
+	httpBody struct {
+		io.ReadCloser
+		release    func()
+		statusCode int
+	}
+
+	// TLSOptions configures the TLS connection to the server
+	TLSOptions struct {
+		// CAFile contains PEM encoded certificates to trust in addition
+		// to the system certificate pool
+		CAFile string `yaml:"ca_file"`
+		// CertFile and KeyFile contain a PEM encoded client certificate
+		// and its key
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		// InsecureSkipVerify disables certificate validation
+		InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	}
+)
+
+// NewHTTPClients creates an empty set of clients
+func NewHTTPClients() *HTTPClients {
+	return &HTTPClients{clients: make(map[httpClientKey]httpClient)}
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexected status %d", e.StatusCode)
+}
+
+// Merge returns a copy of the defaults h overridden by all values set
+// in the given options. Headers are merged by name.
+func (h HTTPOptions) Merge(o HTTPOptions) HTTPOptions {
+	out := h
+
+	if len(h.Headers) > 0 || len(o.Headers) > 0 {
+		out.Headers = make(map[string]Secret, len(h.Headers)+len(o.Headers))
+		maps.Copy(out.Headers, h.Headers)
+		maps.Copy(out.Headers, o.Headers)
+	}
+
+	if o.BasicAuth != nil {
+		out.BasicAuth = o.BasicAuth
+	}
+
+	if o.Proxy != "" {
+		out.Proxy = o.Proxy
+	}
+
+	if o.Timeout > 0 {
+		out.Timeout = o.Timeout
+	}
+
+	if o.TLS != (TLSOptions{}) {
+		out.TLS = o.TLS
+	}
+
+	return out
+}
+
+// Get resolves the value of the secret
+func (s Secret) Get() (string, error) {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", s.Env)
+		}
+		return v, nil
+
+	case s.File != "":
+		v, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %w", err)
+		}
+		return strings.TrimRight(string(v), "\r\n"), nil
+
+	default:
+		return s.Value, nil
+	}
+}
+
+// UnmarshalYAML allows to specify the secret value as plain string in
+// addition to the mapping with `value`, `env` or `file` key
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = Secret{Value: node.Value}
+		return nil
+	}
+
+	type rawSecret Secret
+
+	var raw rawSecret
+	err := node.Decode(&raw)
+	*s = Secret(raw)
+
+	// Type errors must not be wrapped to be collected with the position
+	// of all other type errors in the file
+	return err //nolint:wrapcheck // See above
+}
+
+// Close closes the body and releases the host limit acquired for the
+// request
+func (h httpBody) Close() error {
+	defer h.release()
+	return h.ReadCloser.Close() //nolint:wrapcheck // Transparent wrapper
+}
+
+// StatusCode returns the status code of the response the body belongs to
+func (h httpBody) StatusCode() int { return h.statusCode }
+
+// client returns the given base client or a client using a modified
+// clone of its transport in case custom options are set. Clients are
+// taken from the given clients when set and created for every call
+// otherwise.
+func (h HTTPOptions) client(base *http.Client, clients *HTTPClients) (*http.Client, error) {
+	if h.Proxy == "" && h.Timeout == 0 && h.TLS == (TLSOptions{}) {
+		return base, nil
+	}
+
+	if clients == nil {
+		return h.newClient(base)
+	}
+
+	return clients.get(h, base)
+}
+
+// newClient creates a client using a modified clone of the transport
+// of the base client
+func (h HTTPOptions) newClient(base *http.Client) (*http.Client, error) {
+	baseTransport, ok := base.Transport.(*http.Transport)
+	if !ok || baseTransport == nil {
+		// Custom round-trippers cannot be modified, fall back to defaults
+		baseTransport = http.DefaultTransport.(*http.Transport) //nolint:forcetypeassert // Is always an *http.Transport
+	}
+	transport := baseTransport.Clone()
+
+	switch h.Proxy {
+	case "":
+		// Keep proxy from environment
+
+	case proxyNone:
+		transport.Proxy = nil
+
+	default:
+		proxyURL, err := url.Parse(h.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if h.TLS != (TLSOptions{}) {
+		tlsConfig, err := h.TLS.config()
+		if err != nil {
+			return nil, fmt.Errorf("creating TLS config: %w", err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	timeout := base.Timeout
+	if h.Timeout > 0 {
+		timeout = h.Timeout
+	}
+
+	return &http.Client{
+		CheckRedirect: base.CheckRedirect,
+		Jar:           base.Jar,
+		Timeout:       timeout,
+		Transport:     transport,
+	}, nil
+}
+
+// get returns the client for the options, creating it when none was
+// created for the options or the TLS files changed since
+func (c *HTTPClients) get(h HTTPOptions, base *http.Client) (*http.Client, error) {
+	var (
+		key      = httpClientKey{base: base, proxy: h.Proxy, timeout: h.Timeout, tls: h.TLS}
+		tlsFiles = h.TLS.fileVersions()
+	)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cached, ok := c.clients[key]; ok && cached.tlsFiles == tlsFiles {
+		return cached.client, nil
+	}
+
+	client, err := h.newClient(base)
+	if err != nil {
+		return nil, err
+	}
+
+	c.clients[key] = httpClient{client: client, tlsFiles: tlsFiles}
+	return client, nil
+}
+
+func (t TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify, //#nosec:G402 // Explicitly configured by the user
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if t.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file")
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func (p ProviderDefinition) fetchURLContent(opts SourceOptions) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(opts.Context, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("named-blacklist %s (https://github.com/Luzifer/named-blacklist)", opts.AppVersion))
+
+	for name, secret := range p.HTTP.Headers {
+		v, err := secret.Get()
+		if err != nil {
+			return nil, fmt.Errorf("getting value for header %q: %w", name, err)
+		}
+		req.Header.Set(name, v)
+	}
+
+	if p.HTTP.BasicAuth != nil {
+		user, err := p.HTTP.BasicAuth.Username.Get()
+		if err != nil {
+			return nil, fmt.Errorf("getting basic auth username: %w", err)
+		}
+
+		pass, err := p.HTTP.BasicAuth.Password.Get()
+		if err != nil {
+			return nil, fmt.Errorf("getting basic auth password: %w", err)
+		}
+
+		req.SetBasicAuth(user, pass)
+	}
+
+	client, err := p.HTTP.client(opts.HTTPClient, opts.HTTPClients)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP client: %w", err)
+	}
+
+	release := func() {}
+	if opts.HostLimiter != nil {
+		if release, err = opts.HostLimiter.Acquire(opts.Context, req.URL.Hostname()); err != nil {
+			return nil, fmt.Errorf("waiting for host limit: %w", err)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		release()
+		return nil, HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+
+	return httpBody{ReadCloser: resp.Body, release: release, statusCode: resp.StatusCode}, nil
+}
+
+// fileVersions identifies the content of the TLS files, files which
+// cannot be read result in the zero version and fail creating the client
+func (t TLSOptions) fileVersions() (versions [3]fileVersion) {
+	for i, file := range []string{t.CAFile, t.CertFile, t.KeyFile} {
+		if file == "" {
+			continue
+		}
+
+		if info, err := os.Stat(file); err == nil {
+			versions[i] = fileVersion{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+
+	return versions
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyExtends          = "extends"
+	keyInclude          = "include"
+	keyName             = "name"
+	keyProviderDefaults = "provider_defaults"
+	keyProviders        = "providers"
+)
+
+// providerNode is a provider definition within the merged config
+// together with the file it was defined in
+type providerNode struct {
+	file string
+	node *yaml.Node
+}
+
+// loadFile parses the given file, resolves its includes and returns the
+// merged mapping. The chain contains the files currently being loaded
+// to detect include cycles.
+func (v *validator) loadFile(filename string, chain []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path of %q: %w", filename, err)
+	}
+
+	if slices.Contains(chain, abs) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(chain, abs), " -> "))
+	}
+	chain = append(chain, abs)
+
+	if !slices.Contains(v.loaded, abs) {
+		v.loaded = append(v.loaded, abs)
+	}
+
+	f, err := os.Open(filename) //#nosec:G304 // Intended to load given config file
+	if err != nil {
+		return nil, fmt.Errorf("opening config file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logrus.WithError(err).Error("closing config file")
+		}
+	}()
+
+	var doc yaml.Node
+	if err = yaml.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing config file %q: %w", filename, err)
+	}
+
+	root := documentContent(&doc)
+	v.registerFile(root, filename)
+	v.expandValues(root, filepath.Dir(filename))
+	v.checkKnownFields(root, reflect.TypeFor[File]())
+
+	var (
+		included  []*yaml.Node
+		providers []providerNode
+	)
+
+	for _, pattern := range v.includePatterns(root) {
+		files, err := includeFiles(filepath.Dir(filename), pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, inc := range files {
+			logrus.WithFields(logrus.Fields{"file": filename, "include": inc}).Debug("including config file")
+
+			node, err := v.loadFile(inc, chain)
+			if err != nil {
+				return nil, fmt.Errorf("including %q: %w", inc, err)
+			}
+
+			included = append(included, node)
+			for _, p := range sequenceContent(mappingValue(node, keyProviders)) {
+				providers = append(providers, providerNode{file: v.files[p], node: p})
+			}
+		}
+	}
+
+	defaults := mappingValue(root, keyProviderDefaults)
+	for _, p := range sequenceContent(mappingValue(root, keyProviders)) {
+		providers = v.addProvider(providers, filename, p, defaults)
+	}
+
+	return v.mergeRoot(root, included, providers, filename), nil
+}
+
+// addProvider resolves extends and defaults of the given provider and
+// adds it to the list. A provider having the name of a provider from an
+// included file replaces it.
+func (v *validator) addProvider(providers []providerNode, filename string, p, defaults *yaml.Node) []providerNode {
+	if p = resolveAlias(p); p.Kind != yaml.MappingNode {
+		// Let the decoder report the type error
+		return append(providers, providerNode{file: filename, node: p})
+	}
+
+	if extends := mappingValue(p, keyExtends); extends != nil {
+		idx := slices.IndexFunc(providers, func(pn providerNode) bool {
+			return scalarValue(mappingValue(pn.node, keyName)) == extends.Value
+		})
+
+		if idx < 0 {
+			v.add(extends, "provider %q to extend is not defined", extends.Value)
+		} else {
+			p = v.overlay(providers[idx].node, p, filename)
+		}
+	}
+
+	if defaults != nil {
+		p = v.overlay(defaults, p, filename)
+	}
+
+	name := scalarValue(mappingValue(p, keyName))
+	if idx := slices.IndexFunc(providers, func(pn providerNode) bool {
+		return pn.file != filename && name != "" && scalarValue(mappingValue(pn.node, keyName)) == name
+	}); idx >= 0 {
+		providers[idx] = providerNode{file: filename, node: p}
+		return providers
+	}
+
+	return append(providers, providerNode{file: filename, node: p})
+}
+
+// includePatterns returns the paths / globs listed in the include key
+func (v *validator) includePatterns(root *yaml.Node) (patterns []string) {
+	include := mappingValue(root, keyInclude)
+	if include == nil {
+		return nil
+	}
+
+	if include.Kind == yaml.ScalarNode {
+		return []string{include.Value}
+	}
+
+	for _, n := range sequenceContent(include) {
+		if n.Kind != yaml.ScalarNode {
+			v.add(n, "include must be a path or glob")
+			continue
+		}
+		patterns = append(patterns, n.Value)
+	}
+
+	return patterns
+}
+
+// mergeRoot creates the mapping of the merged config: keys of the file
+// take precedence over keys of included files, providers are replaced
+// by the resolved list
+func (v *validator) mergeRoot(root *yaml.Node, included []*yaml.Node, providers []providerNode, filename string) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	v.files[merged] = filename
+
+	seen := map[string]bool{keyInclude: true, keyProviderDefaults: true, keyProviders: true}
+	for _, m := range append([]*yaml.Node{root}, included...) {
+		if m.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			if seen[m.Content[i].Value] {
+				continue
+			}
+			seen[m.Content[i].Value] = true
+			merged.Content = append(merged.Content, m.Content[i], m.Content[i+1])
+		}
+	}
+
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: root.Line, Column: root.Column}
+	if orig := mappingValue(root, keyProviders); orig != nil {
+		seq.Line, seq.Column = orig.Line, orig.Column
+	}
+	v.files[seq] = filename
+
+	for _, p := range providers {
+		seq.Content = append(seq.Content, p.node)
+	}
+
+	merged.Content = append(merged.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keyProviders, Line: seq.Line, Column: seq.Column},
+		seq,
+	)
+
+	return merged
+}
+
+// overlay creates a provider mapping taking all keys from the given
+// provider and the missing ones from the base. Keys are replaced as a
+// whole, nested mappings are not merged.
+func (v *validator) overlay(base, p *yaml.Node, filename string) *yaml.Node {
+	base = resolveAlias(base)
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: p.Line, Column: p.Column}
+	v.files[merged] = filename
+
+	for i := 0; i+1 < len(p.Content); i += 2 {
+		if p.Content[i].Value == keyExtends {
+			continue
+		}
+		merged.Content = append(merged.Content, p.Content[i], p.Content[i+1])
+	}
+
+	if base == nil || base.Kind != yaml.MappingNode {
+		return merged
+	}
+
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		if base.Content[i].Value == keyExtends || mappingValue(p, base.Content[i].Value) != nil {
+			continue
+		}
+		merged.Content = append(merged.Content, base.Content[i], base.Content[i+1])
+	}
+
+	return merged
+}
+
+// registerFile remembers the file all nodes below the given node were
+// read from to report problems with the right file name
+func (v *validator) registerFile(node *yaml.Node, filename string) {
+	if node == nil {
+		return
+	}
+
+	if _, ok := v.files[node]; ok {
+		return
+	}
+
+	v.files[node] = filename
+	for _, c := range node.Content {
+		v.registerFile(c, filename)
+	}
+}
+
+// includeFiles resolves the include pattern relative to the directory
+// of the including file
+func includeFiles(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("expanding include %q: %w", pattern, err)
+	}
+
+	if len(files) == 0 {
+		logrus.WithField("include", pattern).Warn("include glob did not match any files")
+	}
+
+	slices.Sort(files)
+	return files, nil
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+
+	return node.Value
+}
+
+func sequenceContent(node *yaml.Node) []*yaml.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	return node.Content
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// Source represents a single input of a provider definition. Most
+	// definitions have exactly one source, file globs and directories
+	// yield one source per matched file.
+	Source struct {
+		// Name identifies the source within a definition having multiple
+		// sources (the file name), it is empty for single sources
+		Name string
+
+		open func() (io.ReadCloser, error)
+	}
+
+	// HostLimiter restricts the requests sent to the same host
+	HostLimiter interface {
+		// Acquire blocks until a request to the host may be sent and
+		// returns the function to call after the response was read
+		Acquire(ctx context.Context, host string) (release func(), err error)
+	}
+
+	// SourceOptions control how the sources of a provider definition
+	// are retrieved, the zero value is usable
+	SourceOptions struct {
+		// AppVersion is sent within the User-Agent of HTTP requests
+		AppVersion string
+		// Context cancels running requests and commands, defaults to
+		// context.Background()
+		Context context.Context
+		// HTTPClient executes requests of definitions without custom
+		// HTTP options, defaults to http.DefaultClient. Definitions with
+		// custom options use a clone of its transport.
+		HTTPClient *http.Client
+		// HTTPClients keeps the clients of definitions with custom HTTP
+		// options to reuse them, when nil a client is created for every
+		// request
+		HTTPClients *HTTPClients
+		// HostLimiter is asked before sending a request to the host of
+		// an URL source, when nil requests are not limited
+		HostLimiter HostLimiter
+		// Logger receives warnings about the sources, defaults to the
+		// logrus standard logger
+		Logger logrus.FieldLogger
+	}
+)
+
+// GetSources retrieves the sources of the given list for parsing with
+// a provider
+func (p ProviderDefinition) GetSources(opts SourceOptions) ([]Source, error) {
+	opts = opts.WithDefaults()
+
+	switch {
+	case p.Content != "":
+		return []Source{{open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(p.Content)), nil
+		}}}, nil
+
+	case p.File != "":
+		return p.fileSources(opts.Logger)
+
+	case p.URL != "":
+		return []Source{{open: func() (io.ReadCloser, error) {
+			return p.fetchURLContent(opts)
+		}}}, nil
+
+	case p.Command != nil:
+		return []Source{{open: func() (io.ReadCloser, error) {
+			return p.Command.run(opts.Context)
+		}}}, nil
+
+	default:
+		return nil, fmt.Errorf("neither content, file, URL nor command specified")
+	}
+}
+
+// Open retrieves the content of the source, the caller is responsible
+// for closing it
+func (s Source) Open() (io.ReadCloser, error) {
+	return s.open()
+}
+
+// WithDefaults returns a copy of the options having all unset fields
+// filled with their defaults
+func (o SourceOptions) WithDefaults() SourceOptions {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+
+	if o.Logger == nil {
+		o.Logger = logrus.StandardLogger()
+	}
+
+	return o
+}
+
+// fileSources resolves the file field which might contain a single file,
+// a directory or a glob pattern
+func (p ProviderDefinition) fileSources(logger logrus.FieldLogger) ([]Source, error) {
+	var files []string
+
+	if strings.ContainsAny(p.File, "*?[") {
+		matches, err := filepath.Glob(p.File)
+		if err != nil {
+			return nil, fmt.Errorf("resolving glob: %w", err)
+		}
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				files = append(files, match)
+			}
+		}
+
+		if len(files) == 0 {
+			logger.WithField("provider", p.Name).Warn("file glob did not match any files")
+		}
+	} else {
+		info, err := os.Stat(p.File)
+		if err != nil {
+			return nil, fmt.Errorf("getting file info: %w", err)
+		}
+
+		if !info.IsDir() {
+			return []Source{{open: openFile(p.File)}}, nil
+		}
+
+		dirEntries, err := os.ReadDir(p.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading directory: %w", err)
+		}
+
+		for _, e := range dirEntries {
+			if strings.HasPrefix(e.Name(), ".") || !e.Type().IsRegular() {
+				// Skip hidden files (editor swap files, ...) and everything
+				// not being a regular file
+				continue
+			}
+
+			files = append(files, filepath.Join(p.File, e.Name()))
+		}
+	}
+
+	sort.Strings(files)
+
+	sources := make([]Source, 0, len(files))
+	for _, file := range files {
+		sources = append(sources, Source{Name: file, open: openFile(file)})
+	}
+
+	return sources, nil
+}
+
+func openFile(filename string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(filename) //#nosec:G304 // Intended to load configured lists
+		if err != nil {
+			return nil, fmt.Errorf("opening file: %w", err)
+		}
+		return f, nil
+	}
+}
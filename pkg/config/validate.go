@@ -0,0 +1,633 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+)
+
+type (
+	// ValidationError describes a single problem found in the config
+	// file including its position within the file
+	ValidationError struct {
+		File    string
+		Line    int
+		Column  int
+		Message string
+	}
+
+	// ValidationErrors contains all problems found in the config file
+	ValidationErrors []ValidationError
+
+	// sampleEntry mirrors the fields of provider.Entry (which cannot be
+	// imported here) to test-render templates
+	sampleEntry struct {
+		Domain    string
+		Comments  []string
+		FirstSeen time.Time
+		LastSeen  time.Time
+		Original  string
+		Providers []string
+		Redirect  *Redirect
+		Score     float64
+		Tags      []string
+	}
+
+	validator struct {
+		errs     ValidationErrors
+		filename string
+		files    map[*yaml.Node]string
+		loaded   []string
+		root     *yaml.Node
+		secrets  []string
+	}
+)
+
+var (
+	decodeErrorPosition = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+	knownProviderTypes = map[ProviderType]struct{}{
+		ProviderTypeAdblockPlus: {},
+		ProviderTypeCSV:         {},
+		ProviderTypeDomainList:  {},
+		ProviderTypeHostsFile:   {},
+		ProviderTypeJSON:        {},
+		ProviderTypeManual:      {},
+		ProviderTypeURLList:     {},
+	}
+	knownProviderTypesLock sync.RWMutex
+)
+
+// RegisterProviderType marks the given provider type as known to the
+// config validation
+func RegisterProviderType(t ProviderType) {
+	knownProviderTypesLock.Lock()
+	defer knownProviderTypesLock.Unlock()
+
+	knownProviderTypes[t] = struct{}{}
+}
+
+func (v ValidationError) Error() string {
+	switch {
+	case v.Line == 0:
+		return fmt.Sprintf("%s: %s", v.File, v.Message)
+	case v.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", v.File, v.Line, v.Message)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", v.File, v.Line, v.Column, v.Message)
+}
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+
+	return fmt.Sprintf("found %d problem(s) in config: %s", len(v), strings.Join(msgs, "; "))
+}
+
+// findDecodeErrorNode returns the node below the given node a type
+// error was reported for: nodes of different files can share the line,
+// the value quoted in the message (shortened to 7 characters for longer
+// values) tells them apart.
+func findDecodeErrorNode(node *yaml.Node, line int, msg string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	if node.Line == line && node.Kind != yaml.DocumentNode {
+		value, quoted := decodeErrorValue(msg)
+		if !quoted || (node.Kind == yaml.ScalarNode && strings.HasPrefix(node.Value, value)) {
+			return node
+		}
+	}
+
+	for _, c := range node.Content {
+		if n := findDecodeErrorNode(c, line, msg); n != nil {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// decodeErrorValue extracts the value quoted within a type error
+func decodeErrorValue(msg string) (string, bool) {
+	_, rest, ok := strings.Cut(msg, "`")
+	if !ok {
+		return "", false
+	}
+
+	value, _, ok := strings.Cut(rest, "`")
+	return strings.TrimSuffix(value, "..."), ok
+}
+
+// isValidSeparator checks the separator is a single character usable
+// as CSV separator: quotes, line breaks and the comment character are
+// not allowed
+func isValidSeparator(sep string) bool {
+	r, size := utf8.DecodeRuneInString(sep)
+	if size != len(sep) || r == utf8.RuneError {
+		return false
+	}
+
+	return r == '\t' || (unicode.IsPrint(r) && !strings.ContainsRune("\"#\r\n", r))
+}
+
+func isKnownProviderType(t ProviderType) bool {
+	knownProviderTypesLock.RLock()
+	defer knownProviderTypesLock.RUnlock()
+
+	_, ok := knownProviderTypes[t]
+	return ok
+}
+
+// add records a problem at the position of the given node
+func (v *validator) add(node *yaml.Node, format string, args ...any) {
+	e := ValidationError{File: v.filename, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		e.Line, e.Column = node.Line, node.Column
+		if file, ok := v.files[node]; ok {
+			e.File = file
+		}
+	}
+
+	v.errs = append(v.errs, e)
+}
+
+// addDecodeError records a type error returned by the YAML decoder
+// while decoding the given node. The error only carries the line inside
+// its message, the node it was reported for is looked up below the given
+// node to report the file (which might be an included one) and column.
+func (v *validator) addDecodeError(node *yaml.Node, msg string) {
+	e := ValidationError{File: v.filename, Message: msg}
+
+	if m := decodeErrorPosition.FindStringSubmatch(msg); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Message = m[2]
+
+		if n := findDecodeErrorNode(node, e.Line, e.Message); n != nil {
+			e.Column = n.Column
+			if file, ok := v.files[n]; ok {
+				e.File = file
+			}
+		}
+	}
+
+	v.errs = append(v.errs, e)
+}
+
+// checkKnownFields walks the YAML tree along the given type and records
+// all keys not matching a field of the type
+func (v *validator) checkKnownFields(node *yaml.Node, t reflect.Type) {
+	node = resolveAlias(node)
+	if node == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 1; i < len(node.Content); i += 2 {
+			v.checkKnownFields(node.Content[i], t.Elem())
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for _, item := range node.Content {
+			v.checkKnownFields(item, t.Elem())
+		}
+
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			// Scalars (i.e. time.Time, Secret) are validated by the decoder
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			if key.Value == "<<" {
+				// Merge keys take the fields from the referenced mappings
+				v.checkMergedFields(value, t)
+				continue
+			}
+
+			field, ok := yamlField(t, key.Value)
+			if !ok {
+				v.add(key, "unknown field %q", key.Value)
+				continue
+			}
+
+			v.checkKnownFields(value, field.Type)
+		}
+
+	default:
+		// Scalar types are validated by the decoder
+	}
+}
+
+func (v *validator) checkMergedFields(node *yaml.Node, t reflect.Type) {
+	node = resolveAlias(node)
+
+	if node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			v.checkKnownFields(item, t)
+		}
+		return
+	}
+
+	v.checkKnownFields(node, t)
+}
+
+// compileTemplate parses the template and test-renders it against
+// sample data, problems are recorded at the position of the key in the
+// given parent node
+func (v *validator) compileTemplate(name, tpl string, funcs template.FuncMap, parent *yaml.Node, key string) *template.Template {
+	pos := mappingValue(parent, key)
+	if pos == nil {
+		pos = parent
+	}
+
+	compiled, err := template.New(name).Funcs(funcs).Parse(tpl)
+	if err != nil {
+		v.add(pos, "parsing template: %s", err)
+		return nil
+	}
+
+	if err = compiled.Execute(io.Discard, map[string]any{
+		"blacklist": []sampleEntry{
+			sample("example.com", nil),
+			sample("cname.example.com", &Redirect{CNAME: "walled-garden.example.com"}),
+			sample("local-data.example.com", &Redirect{A: []string{"192.0.2.1"}, AAAA: []string{"2001:db8::1"}}),
+		},
+	}); err != nil {
+		v.add(pos, "rendering template with sample data: %s", err)
+	}
+
+	return compiled
+}
+
+// sample creates an entry to test-render templates with, all possible
+// kinds of redirects are rendered
+func sample(domain string, redirect *Redirect) sampleEntry {
+	return sampleEntry{
+		Domain:    domain,
+		Comments:  []string{`"Sample Provider"`},
+		FirstSeen: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		LastSeen:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Original:  strings.ToUpper(domain) + ".",
+		Providers: []string{"Sample Provider"},
+		Redirect:  redirect,
+		Score:     1,
+		Tags:      []string{"sample"},
+	}
+}
+
+// node resolves the path of mapping keys and sequence indices starting
+// at the document root and returns the deepest node found
+func (v *validator) node(path ...any) *yaml.Node {
+	node := v.root
+
+	for _, elem := range path {
+		var next *yaml.Node
+
+		switch elem := elem.(type) {
+		case int:
+			if node.Kind == yaml.SequenceNode && elem < len(node.Content) {
+				next = resolveAlias(node.Content[elem])
+			}
+
+		case string:
+			next = mappingValue(node, elem)
+		}
+
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+
+	return node
+}
+
+func (v *validator) validateFile(f *File) {
+	if f.State.GracePeriod < 0 {
+		v.add(v.node("state", "grace_period"), "invalid state grace_period %s", f.State.GracePeriod)
+	}
+
+	for _, o := range []struct {
+		key   string
+		value float64
+	}{
+		{"concurrency", float64(f.Fetch.Concurrency)},
+		{"host_concurrency", float64(f.Fetch.HostConcurrency)},
+		{"host_rate_limit", f.Fetch.HostRateLimit},
+	} {
+		if o.value < 0 {
+			v.add(v.node("fetch", o.key), "invalid fetch %s %v", o.key, o.value)
+		}
+	}
+
+	if f.ScoreThreshold < 0 {
+		v.add(v.node("score_threshold"), "invalid score_threshold %v", f.ScoreThreshold)
+	}
+
+	for i, o := range f.Outputs {
+		if o.ScoreThreshold < 0 {
+			v.add(v.node("outputs", i, "score_threshold"), "output %d has invalid score_threshold %v", i, o.ScoreThreshold)
+		}
+	}
+
+	v.validateRedirects(f)
+
+	names := make(map[string]*yaml.Node, len(f.Providers))
+
+	for i, p := range f.Providers {
+		var (
+			label = fmt.Sprintf("provider %q", p.Name)
+			node  = v.node("providers", i)
+		)
+
+		for _, msg := range p.decodeErrors {
+			v.addDecodeError(node, msg)
+		}
+
+		prev, duplicate := names[p.Name]
+		switch {
+		case p.Name == "":
+			label = fmt.Sprintf("provider #%d", i+1)
+			v.add(node, "%s has no name", label)
+
+		case duplicate:
+			v.add(v.node("providers", i, "name"), "%s is already defined in line %d", label, prev.Line)
+
+		default:
+			names[p.Name] = v.node("providers", i, "name")
+		}
+
+		switch p.Action {
+		case ProviderActionBlacklist, ProviderActionWhitelist:
+		default:
+			v.add(v.node("providers", i, "action"), "%s has invalid action %q", label, p.Action)
+		}
+
+		if !isKnownProviderType(p.Type) {
+			v.add(v.node("providers", i, "type"), "%s has unknown type %q", label, p.Type)
+		}
+
+		v.validateSources(p, label, i)
+		v.validateFields(p, label, i)
+		v.validateFilter(p, label, i)
+
+		if _, ok := f.Redirects[p.Redirect]; p.Redirect != "" && !ok {
+			v.add(v.node("providers", i, "redirect"), "%s has unknown redirect %q", label, p.Redirect)
+		}
+
+		if p.SinkholeOnly && p.Type != ProviderTypeHostsFile {
+			v.add(v.node("providers", i, "sinkhole_only"), "%s has sinkhole_only but is not of type %s", label, ProviderTypeHostsFile)
+		}
+
+		if p.MinMatches < 1 {
+			v.add(v.node("providers", i, "min_matches"), "%s has invalid min_matches %d", label, p.MinMatches)
+		}
+
+		if p.Weight <= 0 {
+			v.add(v.node("providers", i, "weight"), "%s has invalid weight %v", label, p.Weight)
+		}
+	}
+}
+
+// validateFields checks the field extraction of structured (JSON / CSV)
+// lists which is otherwise only parsed when executing the provider
+func (v *validator) validateFields(p ProviderDefinition, label string, i int) {
+	if (p.Type == ProviderTypeCSV || p.Type == ProviderTypeJSON) && p.Fields.Domain == "" {
+		v.add(v.node("providers", i, "fields"), "%s of type %s requires fields.domain", label, p.Type)
+	}
+
+	for j, expr := range p.Fields.Filters {
+		if _, err := ParseFieldFilter(expr); err != nil {
+			v.add(v.node("providers", i, "fields", "filters", j), "%s has invalid fields filter: %s", label, err)
+		}
+	}
+
+	if p.Fields.Separator != "" && !isValidSeparator(p.Fields.Separator) {
+		v.add(v.node("providers", i, "fields", "separator"), "%s has invalid fields separator %q, a single character is required", label, p.Fields.Separator)
+	}
+}
+
+// validateFilter checks the domain filter and the transforms of the
+// provider
+func (v *validator) validateFilter(p ProviderDefinition, label string, i int) {
+	if p.Filter.MaxLabels < 0 {
+		v.add(v.node("providers", i, "filter", "max_labels"), "%s has invalid filter max_labels %d", label, p.Filter.MaxLabels)
+	}
+
+	for _, r := range []struct {
+		key   string
+		rules DomainRules
+	}{
+		{"exclude", p.Filter.Exclude},
+		{"include", p.Filter.Include},
+	} {
+		for j, expr := range r.rules.Regex {
+			if _, err := regexp.Compile(expr); err != nil {
+				v.add(v.node("providers", i, "filter", r.key, "regex", j), "%s has invalid %s regex %q: %s", label, r.key, expr, err)
+			}
+		}
+
+		for j, suffix := range r.rules.Suffix {
+			if strings.TrimPrefix(strings.TrimPrefix(suffix, "*"), ".") == "" {
+				v.add(v.node("providers", i, "filter", r.key, "suffix", j), "%s has invalid %s suffix %q", label, r.key, suffix)
+			}
+		}
+
+		for j, tld := range r.rules.TLD {
+			if tld = strings.TrimPrefix(strings.TrimPrefix(tld, "*"), "."); tld == "" || strings.Contains(tld, ".") {
+				v.add(v.node("providers", i, "filter", r.key, "tld", j), "%s has invalid %s tld %q", label, r.key, r.rules.TLD[j])
+			}
+		}
+	}
+
+	for j, t := range p.Transforms {
+		switch t {
+		case DomainTransformETLDPlusOne, DomainTransformStripWWW:
+		default:
+			v.add(v.node("providers", i, "transforms", j), "%s has unknown transform %q", label, t)
+		}
+	}
+}
+
+// validateRedirects checks the redirect targets and the redirects
+// referenced by tags
+func (v *validator) validateRedirects(f *File) {
+	for _, name := range slices.Sorted(maps.Keys(f.Redirects)) {
+		var (
+			node = v.node("redirects", name)
+			r    = f.Redirects[name]
+		)
+
+		switch {
+		case r.CNAME != "" && len(r.A)+len(r.AAAA) > 0:
+			v.add(node, "redirect %q must not have a cname and addresses", name)
+
+		case r.CNAME != "" && !fqdn.IsValidEntry(r.CNAME):
+			v.add(v.node("redirects", name, "cname"), "redirect %q has invalid cname %q", name, r.CNAME)
+
+		case r.CNAME == "" && len(r.A)+len(r.AAAA) == 0:
+			v.add(node, "redirect %q has neither a cname nor addresses", name)
+		}
+
+		for _, a := range r.A {
+			if addr, err := netip.ParseAddr(a); err != nil || !addr.Is4() {
+				v.add(v.node("redirects", name, "a"), "redirect %q has invalid IPv4 address %q", name, a)
+			}
+		}
+
+		for _, a := range r.AAAA {
+			if addr, err := netip.ParseAddr(a); err != nil || !addr.Is6() {
+				v.add(v.node("redirects", name, "aaaa"), "redirect %q has invalid IPv6 address %q", name, a)
+			}
+		}
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(f.TagRedirects)) {
+		if _, ok := f.Redirects[f.TagRedirects[tag]]; !ok {
+			v.add(v.node("tag_redirects", tag), "tag %q has unknown redirect %q", tag, f.TagRedirects[tag])
+		}
+	}
+}
+
+// validateSources ensures the provider has exactly one source and the
+// source matches the provider type
+func (v *validator) validateSources(p ProviderDefinition, label string, i int) {
+	var sources []string
+	for _, src := range []struct {
+		key string
+		set bool
+	}{
+		{"command", p.Command != nil},
+		{"content", p.Content != ""},
+		{"entries", p.Entries != nil},
+		{"file", p.File != ""},
+		{"url", p.URL != ""},
+	} {
+		if src.set {
+			sources = append(sources, src.key)
+		}
+	}
+
+	switch len(sources) {
+	case 0:
+		v.add(v.node("providers", i), "%s has no source (command, content, entries, file or url)", label)
+		return
+
+	case 1:
+		// Exactly what we want
+
+	default:
+		v.add(v.node("providers", i, sources[1]), "%s has multiple sources (%s)", label, strings.Join(sources, ", "))
+		return
+	}
+
+	switch {
+	case p.Type == ProviderTypeManual && sources[0] != "entries":
+		v.add(v.node("providers", i, sources[0]), "%s of type %s requires entries as source", label, p.Type)
+
+	case p.Type != ProviderTypeManual && sources[0] == "entries":
+		v.add(v.node("providers", i, "entries"), "%s has entries but is not of type %s", label, ProviderTypeManual)
+
+	case p.Command != nil && p.Command.Exec == "":
+		v.add(v.node("providers", i, "command"), "%s has a command without exec", label)
+	}
+
+	for j, me := range p.Entries {
+		if domain := strings.TrimSpace(me.Domain); !fqdn.IsValidEntry(domain) {
+			v.add(v.node("providers", i, "entries", j, "domain"), "%s has invalid domain %q in entry %d", label, domain, j+1)
+		}
+	}
+}
+
+// documentContent returns the root node of the document
+func documentContent(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+
+	return node
+}
+
+// mappingValue returns the value of the given key or nil if the node is
+// no mapping or does not contain the key
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
+	}
+
+	return nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// yamlField finds the struct field having the given YAML key
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+
+		if name == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
@@ -1,12 +1,16 @@
-// Package fqdn validates fully qualified domain name entries.
+// Package fqdn validates and normalizes fully qualified domain name
+// entries.
 package fqdn
 
 import (
+	"fmt"
 	"net"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
 const (
@@ -14,6 +18,14 @@ const (
 	maxDomainLabelLength = 63
 )
 
+// normalizeProfile maps domains according to UTS #46 without enforcing
+// the STD3 rules as lists commonly contain labels with underscores
+var normalizeProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
 // IsValidEntry checks the domain names against a validation subset in
 // order to find entries not being domains
 func IsValidEntry(input string) bool {
@@ -62,3 +74,60 @@ func isValidForPlainASCIICheck(input string) bool {
 
 	return true
 }
+
+// Normalize converts the domain into its canonical form: lower-case,
+// without trailing dot and with IDNs in their punycode (ASCII) form as
+// mapped by UTS #46. When stripWWW is set a leading "www." label is
+// removed unless the remainder would be a single label.
+func Normalize(input string, stripWWW bool) (string, error) {
+	domain := strings.TrimSuffix(strings.TrimSpace(input), ".")
+
+	if isASCII(domain) {
+		// Punycode labels are already canonical, no need to pass them
+		// through the IDNA mapping
+		domain = strings.ToLower(domain)
+	} else {
+		var err error
+		if domain, err = normalizeProfile.ToASCII(domain); err != nil {
+			return "", fmt.Errorf("mapping IDN: %w", err)
+		}
+	}
+
+	if stripWWW {
+		domain = StripWWW(domain)
+	}
+
+	return domain, nil
+}
+
+// RegistrableDomain reduces the normalized domain to its effective TLD
+// plus one label (i.e. foo.bar.example.co.uk to example.co.uk). Public
+// suffixes themselves are returned unchanged.
+func RegistrableDomain(domain string) string {
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	return registrable
+}
+
+// StripWWW removes a leading "www." label from the normalized domain
+// unless the remainder would be a single label
+func StripWWW(domain string) string {
+	if rest, ok := strings.CutPrefix(domain, "www."); ok && strings.Contains(rest, ".") {
+		return rest
+	}
+
+	return domain
+}
+
+func isASCII(input string) bool {
+	for i := 0; i < len(input); i++ {
+		if input[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
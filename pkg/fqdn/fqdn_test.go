@@ -18,3 +18,41 @@ func TestIsValidFQDN(t *testing.T) {
 		assert.Equal(t, expResult, IsValidEntry(name), name)
 	}
 }
+
+func TestNormalize(t *testing.T) {
+	for input, exp := range map[string]string{
+		"Example.COM":               "example.com",
+		"example.com.":              "example.com",
+		"Bücher.Example.com.":       "xn--bcher-kva.example.com",
+		"xn--BCHER-kva.example.com": "xn--bcher-kva.example.com",
+		"ｅｘａｍｐｌｅ.com":               "example.com",
+		"www.foo_bar.example.com":   "www.foo_bar.example.com",
+	} {
+		domain, err := Normalize(input, false)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, exp, domain, input)
+		}
+	}
+
+	for input, exp := range map[string]string{
+		"WWW.Example.com":  "example.com",
+		"www.com":          "www.com",
+		"www2.example.com": "www2.example.com",
+	} {
+		domain, err := Normalize(input, true)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, exp, domain, input)
+		}
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	for input, exp := range map[string]string{
+		"foo.bar.example.co.uk": "example.co.uk",
+		"example.com":           "example.com",
+		"co.uk":                 "co.uk",
+		"user.github.io":        "user.github.io",
+	} {
+		assert.Equal(t, exp, RegistrableDomain(input), input)
+	}
+}
@@ -0,0 +1,457 @@
+package generator
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+const (
+	// aggregateBatchSize is the number of entries a provider collects
+	// before passing them to the aggregator to reduce lock contention
+	aggregateBatchSize = 1024
+	// noComment marks a match of a provider not giving a comment
+	noComment = ^uint32(0)
+)
+
+type (
+	// aggregator compiles the entries streamed by the providers into the
+	// blacklist. Domains only reference an interned match (the set of
+	// providers listing them and their comments) instead of carrying
+	// their own slices, which keeps lists having millions of entries in
+	// a few hundred megabytes: most domains share one of a few matches.
+	aggregator struct {
+		lock sync.Mutex
+
+		comments   []commentTable
+		duplicates []int
+		providers  []config.ProviderDefinition
+
+		blacklist map[string]uint32
+		whitelist map[string]uint32
+
+		// originals contains the form a blacklisted domain was listed in
+		// by the first provider listing it, only when it differs from
+		// the canonical form to not store every domain twice
+		originals map[string]string
+
+		matchIndex  map[string]uint32
+		matches     []match
+		transitions map[transition]uint32
+	}
+
+	// commentRef references the comment of the provider with the given
+	// index within the comment table of the provider
+	commentRef struct {
+		provider uint32
+		comment  uint32
+	}
+
+	// commentTable interns the comments of a single provider
+	commentTable struct {
+		index  map[string]uint32
+		values []string
+	}
+
+	// compiledMatch contains the values derived from a match, the
+	// details are shared by all entries referencing the match
+	compiledMatch struct {
+		details           *provider.Details
+		matchingProviders int
+		requiredMatches   int
+	}
+
+	// match is a set of providers listing a domain together with the
+	// comments they gave, both sorted by provider index
+	match struct {
+		comments  []commentRef
+		providers []uint32
+	}
+
+	transition struct {
+		from uint32
+		ref  commentRef
+	}
+)
+
+func newAggregator(providers []config.ProviderDefinition) *aggregator {
+	a := &aggregator{
+		comments:   make([]commentTable, len(providers)),
+		duplicates: make([]int, len(providers)),
+		providers:  providers,
+
+		blacklist: make(map[string]uint32),
+		whitelist: make(map[string]uint32),
+		originals: make(map[string]string),
+
+		// The empty match every domain starts with
+		matchIndex:  map[string]uint32{match{}.key(): 0},
+		matches:     []match{{}},
+		transitions: make(map[transition]uint32),
+	}
+
+	for i := range a.comments {
+		a.comments[i].index = make(map[string]uint32)
+	}
+
+	return a
+}
+
+// add records the entries of the provider with the given index
+func (a *aggregator) add(idx int, entries []provider.Entry) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pIdx := uint32(idx) //#nosec:G115 // Provider count is far below 2^32
+
+	var target map[string]uint32
+	switch a.providers[idx].Action {
+	case config.ProviderActionBlacklist:
+		target = a.blacklist
+	case config.ProviderActionWhitelist:
+		target = a.whitelist
+	default:
+		return
+	}
+
+	for _, e := range entries {
+		domain := e.Domain
+
+		id, ok := target[domain]
+		switch {
+		case !ok:
+			// Domains might be cut from a larger line of the source which
+			// must not be kept in memory with the domain
+			domain = strings.Clone(domain)
+
+		case a.matches[id].hasProvider(pIdx):
+			a.duplicates[idx]++
+		}
+
+		if a.providers[idx].Action == config.ProviderActionBlacklist && (!ok || a.matches[id].providers[0] > pIdx) {
+			// Providers are executed concurrently: the form of the provider
+			// with the lowest index wins to keep the result stable
+			a.setOriginal(domain, e.Original)
+		}
+
+		if a.providers[idx].Action == config.ProviderActionWhitelist || e.Details == nil || len(e.Comments) == 0 {
+			// Comments of whitelists are never rendered
+			id = a.next(id, commentRef{provider: pIdx, comment: noComment})
+		} else {
+			for _, c := range e.Comments {
+				id = a.next(id, commentRef{provider: pIdx, comment: a.comments[idx].intern(c)})
+			}
+		}
+
+		target[domain] = id
+	}
+}
+
+// compile creates the blacklist entries of all domains reaching their
+// threshold and not being whitelisted. Entries sharing a match share
+// their details which must not be modified.
+func (a *aggregator) compile(o options) []provider.Entry {
+	type listed struct {
+		domain string
+		match  uint32
+	}
+
+	var (
+		compiled = make([]*compiledMatch, len(a.matches))
+		kept     = make([]listed, 0, len(a.blacklist))
+	)
+
+	o.stats.ThresholdDrops, o.stats.WhitelistRemovals = 0, 0
+
+	for domain, id := range a.blacklist {
+		if compiled[id] == nil {
+			compiled[id] = a.compileMatch(a.matches[id], a.providers, -1)
+		}
+
+		if !compiled[id].reaches(o) {
+			o.stats.ThresholdDrops++
+			continue
+		}
+
+		if a.whitelisted(domain) {
+			o.stats.WhitelistRemovals++
+			continue
+		}
+
+		kept = append(kept, listed{domain: domain, match: id})
+	}
+
+	// Sorting the small references is way cheaper than sorting the
+	// entries and the domain map is not needed anymore
+	slices.SortFunc(kept, func(x, y listed) int { return strings.Compare(x.domain, y.domain) })
+	a.blacklist = nil
+
+	blacklist := make([]provider.Entry, len(kept))
+	for i, l := range kept {
+		blacklist[i] = provider.Entry{
+			Domain:   l.domain,
+			Original: a.original(l.domain),
+			Details:  compiled[l.match].details,
+		}
+	}
+
+	if o.state != nil {
+		blacklist = o.state.Apply(blacklist, a.whitelisted, a.keptDetails, o.now(), o.gracePeriod)
+	}
+
+	if len(o.redirects) > 0 {
+		applyRedirects(blacklist, a.providers, o)
+	}
+
+	o.stats.Entries = len(blacklist)
+
+	return blacklist
+}
+
+// compileMatch derives score, thresholds and the rendered values from
+// the providers of the match using the given definitions of the
+// providers, the provider at index skip (-1 for none) is left out
+func (a *aggregator) compileMatch(m match, providers []config.ProviderDefinition, skip int) *compiledMatch {
+	var (
+		c      = &compiledMatch{details: new(provider.Details), requiredMatches: math.MaxInt}
+		groups = make(map[string]float64)
+	)
+
+	for _, idx := range m.providers {
+		if int(idx) == skip {
+			continue
+		}
+
+		p := providers[idx]
+
+		c.addMatch(p, groups)
+		c.details.Providers = append(c.details.Providers, p.Name)
+		c.requiredMatches = min(c.requiredMatches, effectiveMinMatches(p))
+		c.details.Tags = mergeUnique(c.details.Tags, p.Tags)
+	}
+
+	for _, ref := range m.comments {
+		if int(ref.provider) == skip {
+			continue
+		}
+		c.details.Comments = append(c.details.Comments, a.comments[ref.provider].values[ref.comment])
+	}
+
+	sort.Strings(c.details.Tags)
+
+	return c
+}
+
+// keptDetails creates the details of a domain kept by the state from
+// the names of the providers which listed it. The comments of the
+// providers are not kept, their names are used instead. Providers not
+// configured anymore are listed without adding to the score or tags.
+func (a *aggregator) keptDetails(providers []string) *provider.Details {
+	var m match
+	for i, p := range a.providers {
+		if p.Action == config.ProviderActionBlacklist && slices.Contains(providers, p.Name) {
+			m.providers = append(m.providers, uint32(i)) //#nosec:G115 // Provider count is far below 2^32
+		}
+	}
+
+	details := a.compileMatch(m, a.providers, -1).details
+	details.Comments = providers
+	details.Providers = providers
+
+	return details
+}
+
+// count returns the number of domains compile would select when using
+// the given definitions of the providers and leaving out the provider
+// at index skip (-1 for none). The domains are kept to count other
+// variations, only the few distinct matches are compiled again.
+func (a *aggregator) count(providers []config.ProviderDefinition, skip int, o options) int {
+	var (
+		compiled    = make([]*compiledMatch, len(a.matches))
+		n           int
+		whitelisted = make(map[uint32]bool)
+	)
+
+	for domain, id := range a.blacklist {
+		if compiled[id] == nil {
+			compiled[id] = a.compileMatch(a.matches[id], providers, skip)
+		}
+
+		if !compiled[id].reaches(o) {
+			continue
+		}
+
+		if wid, ok := a.whitelist[domain]; ok {
+			w, known := whitelisted[wid]
+			if !known {
+				w = slices.ContainsFunc(a.matches[wid].providers, func(idx uint32) bool { return int(idx) != skip })
+				whitelisted[wid] = w
+			}
+
+			if w {
+				continue
+			}
+		}
+
+		n++
+	}
+
+	return n
+}
+
+// next returns the match resulting from adding the given reference to
+// the match with the given index
+func (a *aggregator) next(from uint32, ref commentRef) uint32 {
+	t := transition{from: from, ref: ref}
+	if id, ok := a.transitions[t]; ok {
+		return id
+	}
+
+	cur := a.matches[from]
+	m := match{comments: cur.comments, providers: cur.providers}
+
+	if i, found := slices.BinarySearch(m.providers, ref.provider); !found {
+		m.providers = slices.Insert(slices.Clone(m.providers), i, ref.provider)
+	}
+
+	if ref.comment != noComment {
+		if i, found := slices.BinarySearchFunc(m.comments, ref, compareCommentRefs); !found {
+			m.comments = slices.Insert(slices.Clone(m.comments), i, ref)
+		}
+	}
+
+	key := m.key()
+	id, ok := a.matchIndex[key]
+	if !ok {
+		id = uint32(len(a.matches)) //#nosec:G115 // Number of distinct matches is far below 2^32
+		a.matches = append(a.matches, m)
+		a.matchIndex[key] = id
+	}
+
+	a.transitions[t] = id
+	return id
+}
+
+// original returns the form the domain was listed in
+func (a *aggregator) original(domain string) string {
+	if original, ok := a.originals[domain]; ok {
+		return original
+	}
+
+	return domain
+}
+
+// setOriginal records the form the domain was listed in, forgetting
+// a previously recorded form when it equals the canonical form
+func (a *aggregator) setOriginal(domain, original string) {
+	if original == "" || original == domain {
+		delete(a.originals, domain)
+		return
+	}
+
+	a.originals[domain] = strings.Clone(original)
+}
+
+// stream returns an entrySink passing the entries of the provider in
+// batches to the aggregator
+func (a *aggregator) stream(idx int) (emit func(provider.Entry), done func()) {
+	batch := make([]provider.Entry, 0, aggregateBatchSize)
+
+	done = func() {
+		a.add(idx, batch)
+		batch = batch[:0]
+	}
+
+	return func(e provider.Entry) {
+		batch = append(batch, e)
+		if len(batch) == aggregateBatchSize {
+			done()
+		}
+	}, done
+}
+
+func (a *aggregator) whitelisted(domain string) bool {
+	_, ok := a.whitelist[domain]
+	return ok
+}
+
+// addMatch counts the provider towards the matching providers and adds
+// its weight to the score. Providers sharing a group are counted once
+// using the highest weight within the group.
+func (c *compiledMatch) addMatch(p config.ProviderDefinition, groups map[string]float64) {
+	weight := effectiveWeight(p)
+
+	if p.Group == "" {
+		c.matchingProviders++
+		c.details.Score += weight
+		return
+	}
+
+	prev, ok := groups[p.Group]
+	switch {
+	case !ok:
+		c.matchingProviders++
+		c.details.Score += weight
+		groups[p.Group] = weight
+
+	case weight > prev:
+		c.details.Score += weight - prev
+		groups[p.Group] = weight
+	}
+}
+
+// reaches checks whether the match reaches the min_matches or the score
+// threshold of the options
+func (c *compiledMatch) reaches(o options) bool {
+	if o.scoreThreshold > 0 {
+		return c.details.Score >= o.scoreThreshold
+	}
+
+	return c.matchingProviders >= c.requiredMatches
+}
+
+func (c *commentTable) intern(comment string) uint32 {
+	if idx, ok := c.index[comment]; ok {
+		return idx
+	}
+
+	idx := uint32(len(c.values)) //#nosec:G115 // Number of distinct comments is far below 2^32
+	c.index[comment] = idx
+	c.values = append(c.values, comment)
+
+	return idx
+}
+
+func (m match) hasProvider(idx uint32) bool {
+	_, found := slices.BinarySearch(m.providers, idx)
+	return found
+}
+
+// key encodes the match to find identical matches created by adding
+// the same providers / comments in a different order
+func (m match) key() string {
+	buf := make([]byte, 0, 4*(1+len(m.providers)+2*len(m.comments)))
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.providers))) //#nosec:G115 // Provider count is far below 2^32
+	for _, p := range m.providers {
+		buf = binary.LittleEndian.AppendUint32(buf, p)
+	}
+
+	for _, c := range m.comments {
+		buf = binary.LittleEndian.AppendUint32(buf, c.provider)
+		buf = binary.LittleEndian.AppendUint32(buf, c.comment)
+	}
+
+	return string(buf)
+}
+
+func compareCommentRefs(a, b commentRef) int {
+	return cmp.Or(cmp.Compare(a.provider, b.provider), cmp.Compare(a.comment, b.comment))
+}
@@ -0,0 +1,254 @@
+package generator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+	"github.com/Luzifer/named-blacklist/pkg/state"
+)
+
+func TestAggregatorSharesMatches(t *testing.T) {
+	providers := []config.ProviderDefinition{
+		{Action: config.ProviderActionBlacklist, Name: "A", Tags: []string{"ads"}},
+		{Action: config.ProviderActionBlacklist, Name: "B", Tags: []string{"ads", "malware"}},
+		{Action: config.ProviderActionWhitelist, Name: "W"},
+	}
+
+	agg := newAggregator(providers)
+	agg.add(1, []provider.Entry{
+		{Domain: "a.example.com", Details: &provider.Details{Comments: []string{"B"}}},
+		{Domain: "b.example.com", Details: &provider.Details{Comments: []string{"B"}}},
+	})
+	agg.add(0, []provider.Entry{
+		{Domain: "b.example.com", Details: &provider.Details{Comments: []string{"A"}}},
+		{Domain: "a.example.com", Details: &provider.Details{Comments: []string{"A"}}},
+		{Domain: "a.example.com", Details: &provider.Details{Comments: []string{"A (other)"}}},
+		{Domain: "c.example.com"},
+	})
+	agg.add(2, []provider.Entry{{Domain: "c.example.com", Details: &provider.Details{Comments: []string{"W"}}}})
+
+	blacklist := agg.compile(newOptions(nil))
+
+	assert.Equal(t, []provider.Entry{
+		{Domain: "a.example.com", Original: "a.example.com", Details: &provider.Details{Comments: []string{"A", "A (other)", "B"}, Providers: []string{"A", "B"}, Score: 2, Tags: []string{"ads", "malware"}}},
+		{Domain: "b.example.com", Original: "b.example.com", Details: &provider.Details{Comments: []string{"A", "B"}, Providers: []string{"A", "B"}, Score: 2, Tags: []string{"ads", "malware"}}},
+	}, blacklist)
+	assert.Equal(t, []int{1, 0, 0}, agg.duplicates)
+
+	// The order of adding providers must not create distinct matches
+	// for the same set of providers
+	matchSizes := make(map[int]int)
+	for _, m := range agg.matches {
+		matchSizes[len(m.providers)]++
+	}
+	assert.Equal(t, map[int]int{0: 1, 1: 3, 2: 2}, matchSizes)
+}
+
+func TestGenerateBlacklistMatchesCompileBlacklist(t *testing.T) {
+	providers := benchmarkProviders(t, 10000)
+
+	streamed, err := GenerateBlacklist("testing", providers)
+	require.NoError(t, err)
+
+	results, err := CollectEntries("testing", providers)
+	require.NoError(t, err)
+
+	assert.Equal(t, CompileBlacklist(results), streamed)
+}
+
+func TestSelectionMatchesCompileBlacklist(t *testing.T) {
+	results, err := CollectEntries("testing", []config.ProviderDefinition{
+		{Action: config.ProviderActionBlacklist, Content: "a.example.com\nb.example.com\nc.example.com", Group: "g", MinMatches: 2, Name: "A", Type: "domain-list"},
+		{Action: config.ProviderActionBlacklist, Content: "b.example.com\nc.example.com", Group: "g", MinMatches: 1, Name: "B", Type: "domain-list"},
+		{Action: config.ProviderActionBlacklist, Content: "c.example.com\nd.example.com", MinMatches: 2, Name: "C", Type: "domain-list", Weight: 2},
+		{Action: config.ProviderActionWhitelist, Content: "b.example.com", Name: "W", Type: "domain-list"},
+	})
+	require.NoError(t, err)
+
+	for _, opts := range [][]Option{nil, {WithScoreThreshold(2)}} {
+		s := NewSelection(results, opts...)
+		assert.Equal(t, len(CompileBlacklist(results, opts...)), s.Count())
+
+		for i := range results {
+			without := slices.Concat(results[:i], results[i+1:])
+			assert.Equal(t, len(CompileBlacklist(without, opts...)), s.CountWithout(i), "without %d", i)
+
+			for _, mm := range []int{1, 2, 3} {
+				changed := slices.Clone(results)
+				changed[i].Provider.MinMatches = mm
+				assert.Equal(t, len(CompileBlacklist(changed, opts...)), s.CountWith(i, changed[i].Provider), "provider %d min_matches %d", i, mm)
+			}
+		}
+	}
+}
+
+func BenchmarkGenerateBlacklist(b *testing.B) {
+	// The number of entries in the final blacklist, the three lists
+	// each contain half of them
+	for _, n := range []int{100_000, 1_000_000, 10_000_000} {
+		for _, withState := range []bool{false, true} {
+			b.Run(fmt.Sprintf("entries=%d/state=%v", n, withState), func(b *testing.B) {
+				providers := benchmarkProviders(b, n/2)
+				logger, _ := test.NewNullLogger()
+				stateFile := filepath.Join(b.TempDir(), "state.json")
+
+				if withState {
+					// Measure a run having to load the state of a previous run
+					store, err := state.Load(stateFile)
+					require.NoError(b, err)
+					_, err = GenerateBlacklist("testing", providers, WithLogger(logger), WithState(store, time.Hour))
+					require.NoError(b, err)
+					require.NoError(b, store.Save())
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for range b.N {
+					peak := watchPeakHeap()
+
+					opts := []Option{WithLogger(logger)}
+					var store *state.Store
+					if withState {
+						var err error
+						store, err = state.Load(stateFile)
+						require.NoError(b, err)
+						opts = append(opts, WithState(store, time.Hour))
+					}
+
+					blacklist, err := GenerateBlacklist("testing", providers, opts...)
+					require.NoError(b, err)
+
+					if store != nil {
+						require.NoError(b, store.Save())
+					}
+
+					b.ReportMetric(float64(peak())/(1<<20), "peak-MB")
+
+					// Memory still used by the blacklist after the generation
+					var m runtime.MemStats
+					runtime.GC()
+					runtime.ReadMemStats(&m)
+					b.ReportMetric(float64(m.HeapAlloc)/(1<<20), "live-MB")
+					b.ReportMetric(float64(m.HeapAlloc)/float64(len(blacklist)), "live-B/entry")
+					b.ReportMetric(float64(len(blacklist)), "entries")
+					runtime.KeepAlive(blacklist)
+					runtime.KeepAlive(store)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkAggregatorAdd(b *testing.B) {
+	providers := []config.ProviderDefinition{
+		{Action: config.ProviderActionBlacklist, Name: "A"},
+		{Action: config.ProviderActionBlacklist, Name: "B"},
+	}
+
+	entries := make([]provider.Entry, aggregateBatchSize)
+	for i := range entries {
+		entries[i] = provider.Entry{Domain: fmt.Sprintf("host%d.example.com", i), Details: &provider.Details{Comments: []string{"A"}}}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		agg := newAggregator(providers)
+		agg.add(0, entries)
+		agg.add(1, entries)
+	}
+}
+
+// benchmarkProviders writes three overlapping domain lists having n
+// entries each, half of the domains of a list are shared with the next
+func benchmarkProviders(tb testing.TB, n int) []config.ProviderDefinition {
+	tb.Helper()
+
+	var (
+		dir       = tb.TempDir()
+		providers []config.ProviderDefinition
+	)
+
+	for p := range 3 {
+		file := filepath.Join(dir, fmt.Sprintf("list%d.txt", p))
+
+		f, err := os.Create(file) //#nosec:G304 // Test file in temporary directory
+		require.NoError(tb, err)
+
+		w := bufio.NewWriter(f)
+		for i := p * n / 2; i < p*n/2+n; i++ {
+			_, err = fmt.Fprintf(w, "host%09d.example%03d.com\n", i, i%997)
+			require.NoError(tb, err)
+		}
+		require.NoError(tb, w.Flush())
+		require.NoError(tb, f.Close())
+
+		providers = append(providers, config.ProviderDefinition{
+			Action:     config.ProviderActionBlacklist,
+			File:       file,
+			MinMatches: 1,
+			Name:       fmt.Sprintf("List %d", p),
+			Type:       config.ProviderTypeDomainList,
+		})
+	}
+
+	return providers
+}
+
+// watchPeakHeap samples the heap until the returned function is called
+// which returns the highest heap size seen in the meantime
+func watchPeakHeap() func() uint64 {
+	var (
+		done   = make(chan struct{})
+		peak   uint64
+		sample = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		wg     sync.WaitGroup
+	)
+
+	read := func() {
+		metrics.Read(sample)
+		peak = max(peak, sample[0].Value.Uint64())
+	}
+
+	runtime.GC()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				read()
+				return
+			case <-ticker.C:
+				read()
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
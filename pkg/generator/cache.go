@@ -0,0 +1,158 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+type (
+	// Cache keeps the results of providers between generations to reuse
+	// them for unchanged provider definitions generated using the same
+	// options. Providers reading files or running commands are never
+	// cached as their output changes without changing the definition.
+	Cache struct {
+		entries map[string]cacheEntry
+		lock    sync.Mutex
+	}
+
+	// cacheEntry keeps the entries of a provider in a compact form:
+	// equal comments are stored once for all domains sharing them
+	cacheEntry struct {
+		comments     [][]string
+		commentIndex map[string]uint32
+		domains      []string
+		originals    map[int]string
+		refs         []uint32
+		stats        ProviderStats
+	}
+)
+
+// NewCache creates an empty Cache
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]cacheEntry)}
+}
+
+// Clear removes all results from the cache to execute all providers
+// again on the next generation
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]cacheEntry)
+}
+
+// Prune removes the results of all providers not contained in the given
+// list, i.e. after their definition has been changed, and results
+// created using other options than the given ones
+func (c *Cache) Prune(providers []config.ProviderDefinition, opts ...Option) {
+	o := newOptions(opts)
+
+	keep := make(map[string]struct{}, len(providers))
+	for _, p := range providers {
+		keep[cacheKey(p, o)] = struct{}{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key := range c.entries {
+		if _, ok := keep[key]; !ok {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *Cache) get(p config.ProviderDefinition, o options) (cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[cacheKey(p, o)]
+	return e, ok
+}
+
+func (c *Cache) set(p config.ProviderDefinition, o options, e cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The index is only needed while recording
+	e.commentIndex = nil
+	c.entries[cacheKey(p, o)] = e
+}
+
+// record adds the entry to the cached entries
+func (c *cacheEntry) record(e provider.Entry) {
+	if c.commentIndex == nil {
+		c.commentIndex = make(map[string]uint32)
+	}
+
+	var comments []string
+	if e.Details != nil {
+		comments = e.Comments
+	}
+
+	key := strings.Join(comments, "\x00")
+	ref, ok := c.commentIndex[key]
+	if !ok {
+		ref = uint32(len(c.comments)) //#nosec:G115 // Number of distinct comments is far below 2^32
+		c.commentIndex[key] = ref
+		c.comments = append(c.comments, comments)
+	}
+
+	if e.Original != "" && e.Original != e.Domain {
+		if c.originals == nil {
+			c.originals = make(map[int]string)
+		}
+		c.originals[len(c.domains)] = strings.Clone(e.Original)
+	}
+
+	c.domains = append(c.domains, strings.Clone(e.Domain))
+	c.refs = append(c.refs, ref)
+}
+
+// replay passes the cached entries to emit in the order they were
+// recorded, entries having the same comments share their details
+func (c cacheEntry) replay(emit func(provider.Entry)) {
+	details := make([]*provider.Details, len(c.comments))
+	for i, comments := range c.comments {
+		details[i] = &provider.Details{Comments: comments}
+	}
+
+	for i, domain := range c.domains {
+		original, ok := c.originals[i]
+		if !ok {
+			original = domain
+		}
+
+		emit(provider.Entry{Domain: domain, Original: original, Details: details[c.refs[i]]})
+	}
+}
+
+// cacheable tells whether the result of the provider only depends on
+// its definition: the content of files and the output of commands
+// might change at any time
+func cacheable(p config.ProviderDefinition) bool {
+	return p.File == "" && p.Command == nil
+}
+
+// cacheKey identifies a provider definition by its content and the
+// options changing the entries returned by the provider
+func cacheKey(p config.ProviderDefinition, o options) string {
+	data, err := json.Marshal(struct {
+		Definition config.ProviderDefinition
+		StripWWW   bool
+	}{p, o.stripWWW})
+	if err != nil {
+		// Definitions only contain marshallable types, use the name as
+		// fallback to never panic here
+		return p.Name
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,10 +2,12 @@
 package generator
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"sort"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -14,29 +16,222 @@ import (
 )
 
 type (
-	blacklistAggregate struct {
-		comments          []string
-		matchingProviders int
-		requiredMatches   int
+	// entrySink receives the entries of the provider with the given
+	// index: emit is called for every entry, done after the provider
+	// finished
+	entrySink func(idx int) (emit func(provider.Entry), done func())
+
+	// ProviderStats contains information about the execution of a
+	// single provider
+	ProviderStats struct {
+		provider.Stats
+		Name  string
+		Error error
+		// Cached is set when the result was taken from the cache instead
+		// of executing the provider
+		Cached bool
+		// Duplicates counts entries returned more than once by the
+		// provider which were merged into a single entry
+		Duplicates int
 	}
 
-	providerResult struct {
-		index    int
-		provider config.ProviderDefinition
-		entries  []provider.Entry
+	// RunStats contains information about a blacklist generation
+	RunStats struct {
+		// Duration of the whole generation
+		Duration time.Duration
+		// Entries contained in the final blacklist
+		Entries int
+		// Providers contains the stats of the providers in the order
+		// they were passed to the generator
+		Providers []ProviderStats
+		// ThresholdDrops counts domains dropped because of not reaching
+		// min_matches / score_threshold
+		ThresholdDrops int
+		// WhitelistRemovals counts domains removed by whitelists
+		WhitelistRemovals int
+	}
+
+	// ProviderResult contains the de-duplicated entries returned by a
+	// single provider
+	ProviderResult struct {
+		Provider config.ProviderDefinition
+		Entries  []provider.Entry
+	}
+
+	// Generator executes providers and compiles their entries into the
+	// blacklist using the options it was created with. It can be used
+	// concurrently as long as the passed options (i.e. the Cache) can.
+	// The HTTP clients created for providers having custom HTTP options
+	// are kept for the lifetime of the Generator.
+	Generator struct {
+		clients *config.HTTPClients
+		opts    []Option
+	}
+
+	// Selection keeps provider results aggregated to count the entries
+	// of the blacklist for variations of the provider definitions
+	// without aggregating the entries again
+	Selection struct {
+		agg       *aggregator
+		o         options
+		providers []config.ProviderDefinition
+	}
+
+	// Result contains the outcome of a single generation
+	Result struct {
+		// Blacklist contains the compiled entries sorted by domain
+		Blacklist []provider.Entry
+		// Stats contains information about the generation and every
+		// provider including the errors of failed providers
+		Stats RunStats
 	}
 )
 
+// New creates a Generator using the given options for every generation
+func New(opts ...Option) *Generator {
+	return &Generator{clients: config.NewHTTPClients(), opts: opts}
+}
+
+// Generate executes the providers and compiles the blacklist. Fetching
+// the providers is canceled when the context is done. The result is
+// returned even when the generation fails to inspect the stats of the
+// providers.
+func (g *Generator) Generate(ctx context.Context, providers []config.ProviderDefinition) (*Result, error) {
+	res := new(Result)
+
+	o := newOptions(append(slices.Clone(g.opts), WithContext(ctx), WithRunStats(&res.Stats)))
+	o.httpClients = g.clients
+
+	var err error
+	if res.Blacklist, err = generate(providers, o); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
 // GenerateBlacklist takes a collection of providers and compiles their
 // content into a single list of blacklisted domains
-func GenerateBlacklist(appVersion string, providers []config.ProviderDefinition) (blacklist []provider.Entry, err error) {
+func GenerateBlacklist(appVersion string, providers []config.ProviderDefinition, opts ...Option) (blacklist []provider.Entry, err error) {
+	o := newOptions(opts)
+	o.appVersion = appVersion
+
+	return generate(providers, o)
+}
+
+// CollectEntries executes the providers and returns their entries in
+// the order the providers were passed without compiling them into the
+// blacklist. Use CompileBlacklist to compile the results. All entries
+// are kept in memory, GenerateBlacklist streams them into the blacklist
+// instead.
+func CollectEntries(appVersion string, providers []config.ProviderDefinition, opts ...Option) ([]ProviderResult, error) {
+	o := newOptions(opts)
+	o.appVersion = appVersion
+
+	entries := make([][]provider.Entry, len(providers))
+	if err := executeProviders(providers, o, func(idx int) (func(provider.Entry), func()) {
+		return func(e provider.Entry) { entries[idx] = append(entries[idx], e) }, func() {}
+	}); err != nil {
+		return nil, err
+	}
+
+	results := make([]ProviderResult, len(providers))
+	for i, p := range providers {
+		unique := removeDuplicateEntries(entries[i])
+		o.stats.Providers[i].Duplicates = len(entries[i]) - len(unique)
+		results[i] = ProviderResult{Provider: p, Entries: unique}
+	}
+
+	return results, nil
+}
+
+// CompileBlacklist compiles previously collected provider results into
+// the blacklist. This allows to compile the same results with different
+// providers or settings without fetching them again.
+func CompileBlacklist(results []ProviderResult, opts ...Option) []provider.Entry {
+	o := newOptions(opts)
+	return aggregate(results, o).compile(o)
+}
+
+// NewSelection aggregates previously collected provider results to
+// count the entries of the blacklist for variations of the providers.
+// State and redirects of the options are not used.
+func NewSelection(results []ProviderResult, opts ...Option) *Selection {
+	o := newOptions(opts)
+
+	s := &Selection{agg: aggregate(results, o), o: o, providers: make([]config.ProviderDefinition, len(results))}
+	for i, result := range results {
+		s.providers[i] = result.Provider
+	}
+
+	return s
+}
+
+// Count returns the number of entries the blacklist would contain when
+// compiled from the results
+func (s *Selection) Count() int {
+	return s.agg.count(s.providers, -1, s.o)
+}
+
+// CountWith returns the number of entries the blacklist would contain
+// when the provider at the given index used the given definition. Only
+// the values selecting the entries (i.e. min_matches, weight or group)
+// are used, the entries of the provider are not fetched again.
+func (s *Selection) CountWith(idx int, p config.ProviderDefinition) int {
+	providers := slices.Clone(s.providers)
+	providers[idx] = p
+
+	return s.agg.count(providers, -1, s.o)
+}
+
+// CountWithout returns the number of entries the blacklist would contain
+// without the provider at the given index
+func (s *Selection) CountWithout(idx int) int {
+	return s.agg.count(s.providers, idx, s.o)
+}
+
+// aggregate adds the entries of the results to a new aggregator
+func aggregate(results []ProviderResult, o options) *aggregator {
+	providers := make([]config.ProviderDefinition, len(results))
+	for i, result := range results {
+		providers[i] = result.Provider
+	}
+
+	agg := newAggregator(providers)
+	for i, result := range results {
+		switch result.Provider.Action {
+		case config.ProviderActionBlacklist, config.ProviderActionWhitelist:
+			agg.add(i, result.Entries)
+
+		default:
+			o.logger.WithFields(logrus.Fields{
+				"provider": result.Provider.Name,
+				"action":   result.Provider.Action,
+			}).Warn("skipping provider with invalid action")
+		}
+	}
+
+	return agg
+}
+
+// executeProviders runs the providers concurrently (limited by the
+// concurrency option) and passes their entries to the sink while they
+// are extracted. Results of cached providers are passed to the sink
+// without executing the provider.
+func executeProviders(providers []config.ProviderDefinition, o options, sink entrySink) error {
 	var (
 		errs    []error
-		results = make([]providerResult, len(providers))
+		limiter config.HostLimiter
 		write   = new(sync.Mutex)
 		wg      sync.WaitGroup
 	)
 
+	if o.hostConcurrency > 0 || o.hostRateLimit > 0 {
+		limiter = newHostLimiter(o.hostConcurrency, o.hostRateLimit)
+	}
+
+	*o.stats = RunStats{Providers: make([]ProviderStats, len(providers))}
+
 	for _, p := range providers {
 		switch p.Action {
 		case config.ProviderActionBlacklist, config.ProviderActionWhitelist:
@@ -47,106 +242,139 @@ func GenerateBlacklist(appVersion string, providers []config.ProviderDefinition)
 		if p.MinMatches < 0 {
 			errs = append(errs, fmt.Errorf("invalid min_matches for name %q: %d", p.Name, p.MinMatches))
 		}
+
+		if p.Weight < 0 {
+			errs = append(errs, fmt.Errorf("invalid weight for name %q: %v", p.Name, p.Weight))
+		}
 	}
 
 	if len(errs) > 0 {
-		return nil, fmt.Errorf("collecting entries: %w", errors.Join(errs...))
+		return fmt.Errorf("collecting entries: %w", errors.Join(errs...))
 	}
 
-	wg.Add(len(providers))
-	for i, p := range providers {
-		go func(i int, p config.ProviderDefinition) {
-			defer wg.Done()
+	execute := func(i int, p config.ProviderDefinition) {
+		var (
+			emit, done = sink(i)
+			logger     = o.logger.WithField("provider", p.Name)
+		)
+		defer done()
 
-			logger := logrus.WithField("provider", p.Name)
-			logger.Info("starting domain list extraction")
+		useCache := o.cache != nil && cacheable(p)
+
+		if useCache {
+			if cached, ok := o.cache.get(p, o); ok {
+				logger.Debug("using cached domain list")
+
+				cached.replay(emit)
 
-			entries, err := provider.GetDomainList(appVersion, p)
-			if err != nil {
 				write.Lock()
-				errs = append(errs, fmt.Errorf("getting domain list for %q: %w", p.Name, err))
-				write.Unlock()
+				defer write.Unlock()
+
+				o.stats.Providers[i] = cached.stats
+				o.stats.Providers[i].Cached = true
 				return
 			}
+		}
 
-			write.Lock()
-			results[i] = providerResult{
-				index:    i,
-				provider: p,
-				entries:  removeDuplicateEntries(entries),
-			}
-			write.Unlock()
+		var (
+			recorded cacheEntry
+			stats    = ProviderStats{Name: p.Name}
+			err      = o.ctx.Err()
+		)
 
-			logger.WithField("no_entries", len(entries)).Info("extraction complete")
-		}(i, p)
-	}
+		if err == nil {
+			logger.Info("starting domain list extraction")
 
-	wg.Wait()
+			err = provider.StreamDomainList(provider.Env{
+				SourceOptions: config.SourceOptions{
+					AppVersion:  o.appVersion,
+					Context:     o.ctx,
+					HTTPClient:  o.httpClient,
+					HTTPClients: o.httpClients,
+					HostLimiter: limiter,
+					Logger:      o.logger,
+				},
+				OnReject: o.onReject,
+				Stats:    &stats.Stats,
+				StripWWW: o.stripWWW,
+			}, p, func(e provider.Entry) {
+				if useCache {
+					recorded.record(e)
+				}
+				emit(e)
+			})
+		}
+		stats.Error = err
 
-	if len(errs) > 0 {
-		return nil, fmt.Errorf("collecting entries: %w", errors.Join(errs...))
-	}
+		write.Lock()
+		defer write.Unlock()
 
-	blacklist = compileBlacklist(results)
-	sort.Slice(blacklist, func(i, j int) bool { return blacklist[i].Domain < blacklist[j].Domain })
+		o.stats.Providers[i] = stats
+		if err != nil {
+			errs = append(errs, fmt.Errorf("getting domain list for %q: %w", p.Name, err))
+			return
+		}
 
-	return blacklist, nil
-}
+		if useCache {
+			recorded.stats = stats
+			o.cache.set(p, o, recorded)
+		}
 
-func compileBlacklist(results []providerResult) (blacklist []provider.Entry) {
-	logrus.Info("compiling final blacklist...")
+		logger.WithField("no_entries", stats.Entries).Info("extraction complete")
+	}
 
-	blacklistEntries := make(map[string]*blacklistAggregate)
-	whitelistDomains := make(map[string]struct{})
+	workers := o.concurrency
+	if workers <= 0 || workers > len(providers) {
+		workers = len(providers)
+	}
 
-	for _, result := range results {
-		switch result.provider.Action {
-		case config.ProviderActionBlacklist:
-			for _, entry := range result.entries {
-				aggregate, ok := blacklistEntries[entry.Domain]
-				if !ok {
-					aggregate = &blacklistAggregate{
-						requiredMatches: effectiveMinMatches(result.provider),
-					}
-					blacklistEntries[entry.Domain] = aggregate
-				}
+	// Workers pick the providers in the order they were passed to start
+	// them in a predictable order
+	queue := make(chan int)
 
-				aggregate.matchingProviders++
-				aggregate.requiredMatches = min(aggregate.requiredMatches, effectiveMinMatches(result.provider))
-				aggregate.comments = mergeCommentsUnique(aggregate.comments, entry.Comments)
-			}
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
 
-		case config.ProviderActionWhitelist:
-			for _, entry := range result.entries {
-				whitelistDomains[entry.Domain] = struct{}{}
+			for i := range queue {
+				execute(i, providers[i])
 			}
+		}()
+	}
 
-		default:
-			logrus.WithFields(logrus.Fields{
-				"provider": result.provider.Name,
-				"action":   result.provider.Action,
-			}).Warn("skipping provider with invalid action")
-		}
+	for i := range providers {
+		queue <- i
 	}
+	close(queue)
 
-	for domain, aggregate := range blacklistEntries {
-		if aggregate.matchingProviders < aggregate.requiredMatches {
-			continue
-		}
+	wg.Wait()
 
-		if _, ok := whitelistDomains[domain]; ok {
-			continue
-		}
+	if len(errs) > 0 {
+		return fmt.Errorf("collecting entries: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func generate(providers []config.ProviderDefinition, o options) (blacklist []provider.Entry, err error) {
+	start := time.Now()
+	defer func() { o.stats.Duration = time.Since(start) }()
+
+	agg := newAggregator(providers)
+	if err = executeProviders(providers, o, agg.stream); err != nil {
+		return nil, err
+	}
 
-		blacklist = append(blacklist, provider.Entry{
-			Domain:   domain,
-			Comments: aggregate.comments,
-		})
+	for i := range providers {
+		o.stats.Providers[i].Duplicates = agg.duplicates[i]
 	}
 
-	logrus.Info("done")
+	o.logger.Info("compiling final blacklist...")
+	blacklist = agg.compile(o)
+	o.logger.Info("done")
 
-	return blacklist
+	return blacklist, nil
 }
 
 func effectiveMinMatches(p config.ProviderDefinition) int {
@@ -157,7 +385,15 @@ func effectiveMinMatches(p config.ProviderDefinition) int {
 	return p.MinMatches
 }
 
-func mergeCommentsUnique(existing, incoming []string) []string {
+func effectiveWeight(p config.ProviderDefinition) float64 {
+	if p.Weight == 0 {
+		return 1
+	}
+
+	return p.Weight
+}
+
+func mergeUnique(existing, incoming []string) []string {
 	seen := make(map[string]struct{}, len(existing))
 
 	for _, comment := range existing {
@@ -182,7 +418,17 @@ func removeDuplicateEntries(list []provider.Entry) (unique []provider.Entry) {
 	for _, e := range list {
 		i, contains := keys[e.Domain]
 		if contains {
-			unique[i].Comments = mergeCommentsUnique(unique[i].Comments, e.Comments)
+			if e.Details == nil {
+				continue
+			}
+
+			// Details might be shared with other entries of the provider
+			var details provider.Details
+			if unique[i].Details != nil {
+				details = *unique[i].Details
+			}
+			details.Comments = mergeUnique(slices.Clone(details.Comments), e.Comments)
+			unique[i].Details = &details
 			continue
 		}
 
@@ -1,9 +1,18 @@
 package generator
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -43,8 +52,8 @@ func TestGenerateBlacklistDefaultBehavior(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.Equal(t, []provider.Entry{
-		{Domain: "a.example.com", Comments: []string{"Local Blacklist", "Second Local Blacklist"}},
-		{Domain: "c.example.com", Comments: []string{"Local Blacklist"}},
+		{Domain: "a.example.com", Original: "a.example.com", Details: &provider.Details{Comments: []string{"Local Blacklist", "Second Local Blacklist"}, Providers: []string{"Local Blacklist", "Second Local Blacklist"}, Score: 2}},
+		{Domain: "c.example.com", Original: "c.example.com", Details: &provider.Details{Comments: []string{"Local Blacklist"}, Providers: []string{"Local Blacklist"}, Score: 1}},
 	}, b)
 }
 
@@ -100,9 +109,448 @@ func TestGenerateBlacklistMinMatches(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.Equal(t, []provider.Entry{
-		{Domain: "duplicate.example.com", Comments: []string{"Trusted Feed", "Noisy Feed"}},
-		{Domain: "once.example.com", Comments: []string{"Trusted Feed"}},
-		{Domain: "pair.example.com", Comments: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}},
-		{Domain: "triple.example.com", Comments: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}},
+		{Domain: "duplicate.example.com", Original: "duplicate.example.com", Details: &provider.Details{Comments: []string{"Trusted Feed", "Noisy Feed"}, Providers: []string{"Trusted Feed", "Noisy Feed"}, Score: 2}},
+		{Domain: "once.example.com", Original: "once.example.com", Details: &provider.Details{Comments: []string{"Trusted Feed"}, Providers: []string{"Trusted Feed"}, Score: 1}},
+		{Domain: "pair.example.com", Original: "pair.example.com", Details: &provider.Details{Comments: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}, Providers: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}, Score: 3}},
+		{Domain: "triple.example.com", Original: "triple.example.com", Details: &provider.Details{Comments: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}, Providers: []string{"Trusted Feed", "Noisy Feed", "Strict Feed"}, Score: 3}},
 	}, b)
 }
+
+func TestGenerateBlacklistFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "incident-1.txt"), []byte("a.example.com\nshared.example.com\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "incident-2.txt"), []byte("shared.example.com\n"), 0o600))
+
+	b, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			File:       dir,
+			MinMatches: 1,
+			Name:       "Incidents",
+			Type:       "domain-list",
+		},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []provider.Entry{
+		{Domain: "a.example.com", Original: "a.example.com", Details: &provider.Details{Comments: []string{"Incidents (" + filepath.Join(dir, "incident-1.txt") + ")"}, Providers: []string{"Incidents"}, Score: 1}},
+		{Domain: "shared.example.com", Original: "shared.example.com", Details: &provider.Details{Comments: []string{
+			"Incidents (" + filepath.Join(dir, "incident-1.txt") + ")",
+			"Incidents (" + filepath.Join(dir, "incident-2.txt") + ")",
+		}, Providers: []string{"Incidents"}, Score: 1}},
+	}, b)
+}
+
+func TestGenerateBlacklistCarriesTags(t *testing.T) {
+	b, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "ads.example.com\nboth.example.com",
+			MinMatches: 1,
+			Name:       "Ads",
+			Tags:       []string{"ads"},
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "both.example.com",
+			MinMatches: 1,
+			Name:       "Malware",
+			Tags:       []string{"malware", "ads"},
+			Type:       "domain-list",
+		},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []provider.Entry{
+		{Domain: "ads.example.com", Original: "ads.example.com", Details: &provider.Details{Comments: []string{"Ads"}, Providers: []string{"Ads"}, Score: 1, Tags: []string{"ads"}}},
+		{Domain: "both.example.com", Original: "both.example.com", Details: &provider.Details{Comments: []string{"Ads", "Malware"}, Providers: []string{"Ads", "Malware"}, Score: 2, Tags: []string{"ads", "malware"}}},
+	}, b)
+}
+
+func TestGenerateBlacklistScoreThreshold(t *testing.T) {
+	b, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "curated.example.com\nboth.example.com",
+			MinMatches: 3,
+			Name:       "Curated Malware",
+			Type:       "domain-list",
+			Weight:     3,
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "noisy.example.com\nboth.example.com\nconfirmed.example.com",
+			MinMatches: 1,
+			Name:       "Community",
+			Type:       "domain-list",
+			Weight:     0.5,
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "confirmed.example.com",
+			MinMatches: 1,
+			Name:       "Second Community",
+			Type:       "domain-list",
+			Weight:     2.5,
+		},
+	}, WithScoreThreshold(3))
+
+	require.NoError(t, err)
+	assert.Equal(t, []provider.Entry{
+		{Domain: "both.example.com", Original: "both.example.com", Details: &provider.Details{Comments: []string{"Curated Malware", "Community"}, Providers: []string{"Curated Malware", "Community"}, Score: 3.5}},
+		{Domain: "confirmed.example.com", Original: "confirmed.example.com", Details: &provider.Details{Comments: []string{"Community", "Second Community"}, Providers: []string{"Community", "Second Community"}, Score: 3}},
+		{Domain: "curated.example.com", Original: "curated.example.com", Details: &provider.Details{Comments: []string{"Curated Malware"}, Providers: []string{"Curated Malware"}, Score: 3}},
+	}, b)
+}
+
+func TestGenerateBlacklistGroupsCountOnce(t *testing.T) {
+	b, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "mirrored.example.com\nconfirmed.example.com",
+			Group:      "StevenBlack",
+			MinMatches: 2,
+			Name:       "StevenBlack",
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "mirrored.example.com\nconfirmed.example.com",
+			Group:      "StevenBlack",
+			MinMatches: 2,
+			Name:       "StevenBlack Fork",
+			Type:       "domain-list",
+			Weight:     2,
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "confirmed.example.com",
+			MinMatches: 2,
+			Name:       "Independent",
+			Type:       "domain-list",
+		},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []provider.Entry{
+		{Domain: "confirmed.example.com", Original: "confirmed.example.com", Details: &provider.Details{Comments: []string{"StevenBlack", "StevenBlack Fork", "Independent"}, Providers: []string{"StevenBlack", "StevenBlack Fork", "Independent"}, Score: 3}},
+	}, b)
+}
+
+func TestGenerateBlacklistNormalizesDomains(t *testing.T) {
+	providers := []config.ProviderDefinition{
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "Bücher.Example.com.\nWWW.Example.com\nAllowed.Example.com.",
+			Name:    "Mixed Case",
+			Type:    "domain-list",
+		},
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "xn--bcher-kva.example.com\nwww.example.com",
+			Name:    "Punycode",
+			Type:    "domain-list",
+		},
+		{
+			Action:  config.ProviderActionWhitelist,
+			Content: "allowed.example.com",
+			Name:    "Whitelist",
+			Type:    "domain-list",
+		},
+	}
+
+	b, err := GenerateBlacklist("testing", providers)
+	require.NoError(t, err)
+	assert.Equal(t, []provider.Entry{
+		{Domain: "www.example.com", Original: "WWW.Example.com", Details: &provider.Details{Comments: []string{"Mixed Case", "Punycode"}, Providers: []string{"Mixed Case", "Punycode"}, Score: 2}},
+		{Domain: "xn--bcher-kva.example.com", Original: "Bücher.Example.com.", Details: &provider.Details{Comments: []string{"Mixed Case", "Punycode"}, Providers: []string{"Mixed Case", "Punycode"}, Score: 2}},
+	}, b)
+
+	b, err = GenerateBlacklist("testing", providers, WithStripWWW(true))
+	require.NoError(t, err)
+	require.Len(t, b, 2)
+	assert.Equal(t, "example.com", b[0].Domain)
+	assert.Equal(t, "WWW.Example.com", b[0].Original)
+}
+
+func TestGenerateBlacklistRedirects(t *testing.T) {
+	var (
+		local    = config.Redirect{A: []string{"192.0.2.1"}}
+		phishing = config.Redirect{CNAME: "warning.example.net"}
+	)
+
+	b, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "ads.example.com\nboth.example.com",
+			Name:    "Ads",
+			Type:    "domain-list",
+		},
+		{
+			Action:  config.ProviderActionBlacklist,
+			Content: "both.example.com\nphish.example.com",
+			Name:    "Phishing",
+			Tags:    []string{"phishing"},
+			Type:    "domain-list",
+		},
+		{
+			Action:   config.ProviderActionBlacklist,
+			Content:  "both.example.com\nlocal.example.com",
+			Name:     "Local",
+			Redirect: "local",
+			Type:     "domain-list",
+		},
+	}, WithRedirects(
+		map[string]config.Redirect{"local": local, "phishing": phishing},
+		map[string]string{"phishing": "phishing"},
+	))
+	require.NoError(t, err)
+
+	redirects := make(map[string]*config.Redirect)
+	for _, e := range b {
+		redirects[e.Domain] = e.Redirect
+	}
+
+	assert.Equal(t, map[string]*config.Redirect{
+		"ads.example.com":   nil,
+		"both.example.com":  &local,
+		"local.example.com": &local,
+		"phish.example.com": &phishing,
+	}, redirects)
+}
+
+func TestGenerateBlacklistRunStats(t *testing.T) {
+	var stats RunStats
+
+	_, err := GenerateBlacklist("testing", []config.ProviderDefinition{
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "a.example.com\nb.example.com\nc.example.com\nnot a domain\nlocalhost\na.example.com",
+			MinMatches: 1,
+			Name:       "Feed",
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionBlacklist,
+			Content:    "unconfirmed.example.com",
+			MinMatches: 2,
+			Name:       "Noisy Feed",
+			Type:       "domain-list",
+		},
+		{
+			Action:     config.ProviderActionWhitelist,
+			Content:    "b.example.com",
+			MinMatches: 1,
+			Name:       "Whitelist",
+			Type:       "domain-list",
+		},
+	}, WithRunStats(&stats))
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, 1, stats.ThresholdDrops)
+	assert.Equal(t, 1, stats.WhitelistRemovals)
+
+	require.Len(t, stats.Providers, 3)
+	assert.Equal(t, "Feed", stats.Providers[0].Name)
+	assert.NoError(t, stats.Providers[0].Error)
+	assert.Equal(t, 4, stats.Providers[0].Entries)
+	assert.Equal(t, 1, stats.Providers[0].Duplicates)
+	assert.Equal(t, 2, stats.Providers[0].Rejected)
+	assert.Equal(t, map[provider.RejectReason]int{
+		provider.RejectReasonGenericBlacklist: 1,
+		provider.RejectReasonInvalidFormat:    1,
+	}, stats.Providers[0].RejectedByReason)
+	assert.Equal(t, int64(78), stats.Providers[0].Bytes)
+}
+
+func TestGenerateBlacklistUsesCache(t *testing.T) {
+	var (
+		cache = NewCache()
+		dir   = t.TempDir()
+		file  = filepath.Join(dir, "list.txt")
+		stats RunStats
+	)
+
+	require.NoError(t, os.WriteFile(file, []byte("a.example.com\n"), 0o600))
+
+	providers := []config.ProviderDefinition{
+		{Action: config.ProviderActionBlacklist, Content: "www.b.example.com", MinMatches: 1, Name: "Content", Type: "domain-list"},
+		{Action: config.ProviderActionBlacklist, Content: "c.example.com", MinMatches: 1, Name: "Other", Type: "domain-list"},
+		{Action: config.ProviderActionBlacklist, File: file, MinMatches: 1, Name: "File", Type: "domain-list"},
+	}
+
+	blacklist, err := GenerateBlacklist("testing", providers, WithCache(cache), WithRunStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com", "c.example.com", "www.b.example.com"}, domains(blacklist))
+	assert.False(t, stats.Providers[0].Cached)
+
+	// Files are read again as their content might have changed
+	require.NoError(t, os.WriteFile(file, []byte("d.example.com\n"), 0o600))
+
+	blacklist, err = GenerateBlacklist("testing", providers, WithCache(cache), WithRunStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.example.com", "d.example.com", "www.b.example.com"}, domains(blacklist))
+	assert.True(t, stats.Providers[0].Cached)
+	assert.Equal(t, 1, stats.Providers[0].Entries)
+	assert.False(t, stats.Providers[2].Cached)
+
+	// Changing the definition of a provider keeps the other one cached
+	providers[1].Content = "e.example.com"
+	cache.Prune(providers)
+
+	blacklist, err = GenerateBlacklist("testing", providers, WithCache(cache), WithRunStats(&stats))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d.example.com", "e.example.com", "www.b.example.com"}, domains(blacklist))
+	assert.True(t, stats.Providers[0].Cached)
+	assert.False(t, stats.Providers[1].Cached)
+
+	// Results normalized using other options are not reused
+	cache.Prune(providers, WithStripWWW(true))
+
+	blacklist, err = GenerateBlacklist("testing", providers, WithCache(cache), WithRunStats(&stats), WithStripWWW(true))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.example.com", "d.example.com", "e.example.com"}, domains(blacklist))
+	assert.False(t, stats.Providers[0].Cached)
+	assert.False(t, stats.Providers[1].Cached)
+
+	cache.Clear()
+
+	_, err = GenerateBlacklist("testing", providers, WithCache(cache), WithRunStats(&stats))
+	require.NoError(t, err)
+	assert.False(t, stats.Providers[0].Cached)
+}
+
+func TestGeneratorGenerate(t *testing.T) {
+	var userAgent string
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		_, _ = fmt.Fprintln(w, "a.example.com")
+	}))
+	t.Cleanup(srv.Close)
+
+	logger, hook := test.NewNullLogger()
+
+	gen := New(
+		WithAppVersion("v1.2.3"),
+		WithHTTPClient(srv.Client()),
+		WithLogger(logger),
+	)
+
+	providers := []config.ProviderDefinition{
+		{Action: config.ProviderActionBlacklist, URL: srv.URL, MinMatches: 1, Name: "Remote", Type: "domain-list"},
+		{Action: config.ProviderActionBlacklist, Content: "b.example.com", MinMatches: 1, Name: "Content", Type: "domain-list"},
+	}
+
+	res, err := gen.Generate(context.Background(), providers)
+	require.NoError(t, err)
+
+	assert.Equal(t, []provider.Entry{
+		{Domain: "a.example.com", Original: "a.example.com", Details: &provider.Details{Comments: []string{"Remote"}, Providers: []string{"Remote"}, Score: 1}},
+		{Domain: "b.example.com", Original: "b.example.com", Details: &provider.Details{Comments: []string{"Content"}, Providers: []string{"Content"}, Score: 1}},
+	}, res.Blacklist)
+	assert.Equal(t, 2, res.Stats.Entries)
+	assert.Equal(t, http.StatusOK, res.Stats.Providers[0].HTTPStatus)
+	assert.Contains(t, userAgent, "named-blacklist v1.2.3")
+	assert.NotEmpty(t, hook.AllEntries())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, err = gen.Generate(ctx, providers)
+	require.Error(t, err)
+	require.Len(t, res.Stats.Providers, 2)
+	assert.ErrorIs(t, res.Stats.Providers[0].Error, context.Canceled)
+	assert.ErrorIs(t, res.Stats.Providers[1].Error, context.Canceled)
+}
+
+func TestGenerateBlacklistLimitsConcurrency(t *testing.T) {
+	var (
+		inFlight, maxInFlight int
+		lock                  sync.Mutex
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		_, _ = fmt.Fprintf(w, "%s.example.com\n", strings.Trim(r.URL.Path, "/"))
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	var providers []config.ProviderDefinition
+	for i := range 6 {
+		providers = append(providers, config.ProviderDefinition{
+			Action:     config.ProviderActionBlacklist,
+			MinMatches: 1,
+			Name:       fmt.Sprintf("List %d", i),
+			Type:       config.ProviderTypeDomainList,
+			URL:        fmt.Sprintf("%s/list%d", srv.URL, i),
+		})
+	}
+
+	for _, tc := range []struct {
+		name        string
+		opts        []Option
+		maxInFlight int
+	}{
+		{name: "unlimited", maxInFlight: 6},
+		{name: "concurrency", opts: []Option{WithConcurrency(2)}, maxInFlight: 2},
+		{name: "host", opts: []Option{WithHostLimits(1, 0)}, maxInFlight: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			maxInFlight = 0
+
+			blacklist, err := GenerateBlacklist("testing", providers, tc.opts...)
+			require.NoError(t, err)
+			require.Len(t, blacklist, 6)
+			assert.Equal(t, "list0.example.com", blacklist[0].Domain)
+
+			assert.LessOrEqual(t, maxInFlight, tc.maxInFlight)
+			if tc.maxInFlight == 6 {
+				assert.Greater(t, maxInFlight, 1)
+			}
+		})
+	}
+}
+
+func TestGenerateBlacklistHostRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintln(w, "a.example.com")
+	}))
+	t.Cleanup(srv.Close)
+
+	providers := make([]config.ProviderDefinition, 3)
+	for i := range providers {
+		providers[i] = config.ProviderDefinition{
+			Action:     config.ProviderActionBlacklist,
+			MinMatches: 1,
+			Name:       fmt.Sprintf("List %d", i),
+			Type:       config.ProviderTypeDomainList,
+			URL:        srv.URL,
+		}
+	}
+
+	start := time.Now()
+	_, err := GenerateBlacklist("testing", providers, WithHostLimits(0, 20))
+	require.NoError(t, err)
+
+	// The first request is sent immediately, the others wait 50ms each
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func domains(entries []provider.Entry) (out []string) {
+	for _, e := range entries {
+		out = append(out, e.Domain)
+	}
+	return out
+}
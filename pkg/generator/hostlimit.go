@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+type (
+	// hostLimiter limits the concurrent requests and the request rate
+	// for every host separately
+	hostLimiter struct {
+		concurrency int
+		rate        float64
+
+		hosts map[string]*hostLimit
+		lock  sync.Mutex
+	}
+
+	hostLimit struct {
+		rate  *rate.Limiter
+		slots chan struct{}
+	}
+)
+
+func newHostLimiter(concurrency int, ratePerSecond float64) *hostLimiter {
+	return &hostLimiter{
+		concurrency: concurrency,
+		hosts:       make(map[string]*hostLimit),
+		rate:        ratePerSecond,
+	}
+}
+
+// Acquire blocks until a request to the host may be sent regarding the
+// concurrency limit and the request rate
+func (h *hostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	l := h.get(host)
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for free connection: %w", ctx.Err())
+		}
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}
+
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, fmt.Errorf("waiting for rate limit: %w", err)
+		}
+	}
+
+	return release, nil
+}
+
+func (h *hostLimiter) get(host string) *hostLimit {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if l, ok := h.hosts[host]; ok {
+		return l
+	}
+
+	l := new(hostLimit)
+	if h.concurrency > 0 {
+		l.slots = make(chan struct{}, h.concurrency)
+	}
+	if h.rate > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(h.rate), 1)
+	}
+
+	h.hosts[host] = l
+	return l
+}
@@ -0,0 +1,164 @@
+package generator
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+	"github.com/Luzifer/named-blacklist/pkg/state"
+)
+
+type (
+	// Option configures the generation of the blacklist
+	Option func(*options)
+
+	options struct {
+		appVersion      string
+		cache           *Cache
+		concurrency     int
+		ctx             context.Context
+		gracePeriod     time.Duration
+		hostConcurrency int
+		hostRateLimit   float64
+		httpClient      *http.Client
+		httpClients     *config.HTTPClients
+		logger          logrus.FieldLogger
+		now             func() time.Time
+		onReject        func(provider.Rejection)
+		redirects       map[string]config.Redirect
+		scoreThreshold  float64
+		state           *state.Store
+		stats           *RunStats
+		stripWWW        bool
+		tagRedirects    map[string]string
+	}
+)
+
+func newOptions(opts []Option) options {
+	o := options{
+		ctx:         context.Background(),
+		httpClients: config.NewHTTPClients(),
+		logger:      logrus.StandardLogger(),
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.stats == nil {
+		o.stats = new(RunStats)
+	}
+
+	return o
+}
+
+// ScoringEnabled reports whether the given options select the blacklist
+// entries by their score instead of the min_matches of the providers
+func ScoringEnabled(opts ...Option) bool {
+	return newOptions(opts).scoreThreshold > 0
+}
+
+// WithAppVersion sets the version sent within the User-Agent of HTTP
+// requests to the provider URLs
+func WithAppVersion(version string) Option {
+	return func(o *options) { o.appVersion = version }
+}
+
+// WithCache reuses the results of providers found in the cache instead
+// of executing them and stores the results of executed providers. The
+// cache keeps a copy of all cached entries, providers reading files or
+// running commands are not cached.
+func WithCache(cache *Cache) Option {
+	return func(o *options) { o.cache = cache }
+}
+
+// WithConcurrency limits the number of providers executed at the same
+// time. Providers are started in the order they were passed. Zero (the
+// default) executes all providers at once.
+func WithConcurrency(n int) Option {
+	return func(o *options) { o.concurrency = n }
+}
+
+// WithContext cancels fetching the providers when the given context is
+// done. Generator.Generate uses the context passed to it instead.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) { o.ctx = ctx }
+}
+
+// WithHostLimits limits the number of concurrent requests and the
+// requests per second sent to the same host by URL providers. Zero
+// disables the respective limit.
+func WithHostLimits(concurrency int, ratePerSecond float64) Option {
+	return func(o *options) {
+		o.hostConcurrency = concurrency
+		o.hostRateLimit = ratePerSecond
+	}
+}
+
+// WithHTTPClient executes the requests of URL providers using the given
+// client instead of http.DefaultClient. Providers having custom HTTP
+// options use a clone of its transport, created once per generation
+// (or once per Generator) for every set of options.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) { o.httpClient = client }
+}
+
+// WithLogger sends the log output of the generation and the providers
+// to the given logger instead of the logrus standard logger
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithScoreThreshold switches the selection of blacklist entries from
+// min_matches to weighted scoring: a domain is included when the sum
+// of the weights of the providers listing it reaches the threshold.
+// A threshold of zero keeps the min_matches selection.
+func WithScoreThreshold(threshold float64) Option {
+	return func(o *options) { o.scoreThreshold = threshold }
+}
+
+// WithState records the listed domains in the given store and keeps
+// domains for the grace period after they dropped out of all lists
+func WithState(store *state.Store, gracePeriod time.Duration) Option {
+	return func(o *options) {
+		o.gracePeriod = gracePeriod
+		o.state = store
+	}
+}
+
+// WithStripWWW removes a leading "www." label from all domains while
+// normalizing them, i.e. www.example.com and example.com are merged
+func WithStripWWW(strip bool) Option {
+	return func(o *options) { o.stripWWW = strip }
+}
+
+// WithRedirects sets the named redirects referenced by the providers
+// and the tags (tag to redirect name) to select for the entries of the
+// blacklist. The redirect of the first provider listing an entry is
+// used, when no provider has one the redirect of its first tag.
+func WithRedirects(redirects map[string]config.Redirect, tagRedirects map[string]string) Option {
+	return func(o *options) {
+		o.redirects = redirects
+		o.tagRedirects = tagRedirects
+	}
+}
+
+// WithRejections passes every line / record rejected by the providers
+// to the given function. It is called from all providers executed at
+// the same time and needs to synchronize itself. Providers reused from
+// the cache do not report their rejections again.
+func WithRejections(fn func(provider.Rejection)) Option {
+	return func(o *options) { o.onReject = fn }
+}
+
+// WithRunStats stores information about the generation and the execution
+// of every provider into the given stats. The stats are filled even when
+// the generation fails.
+func WithRunStats(stats *RunStats) Option {
+	return func(o *options) { o.stats = stats }
+}
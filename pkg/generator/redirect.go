@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+// applyRedirects sets the redirect of the first provider listing the
+// entry having one, falling back to the redirect of the first tag of
+// the entry having one. Entries share the redirects. The details of the
+// entries are created by the compilation and are modified in place:
+// entries sharing them have the same providers and tags.
+func applyRedirects(blacklist []provider.Entry, providers []config.ProviderDefinition, o options) {
+	var (
+		byName     = make(map[string]*config.Redirect, len(o.redirects))
+		byProvider = make(map[string]*config.Redirect)
+		byTag      = make(map[string]*config.Redirect, len(o.tagRedirects))
+	)
+
+	for name, r := range o.redirects {
+		byName[name] = &r
+	}
+
+	for _, p := range providers {
+		if r, ok := byName[p.Redirect]; ok {
+			byProvider[p.Name] = r
+		}
+	}
+
+	for tag, name := range o.tagRedirects {
+		if r, ok := byName[name]; ok {
+			byTag[tag] = r
+		}
+	}
+
+	for i, e := range blacklist {
+		blacklist[i].Redirect = redirectFor(e, byProvider, byTag)
+	}
+}
+
+// redirectFor selects the redirect of the entry, the providers are
+// sorted in the order they are configured and the tags by name
+func redirectFor(e provider.Entry, byProvider, byTag map[string]*config.Redirect) *config.Redirect {
+	for _, name := range e.Providers {
+		if r, ok := byProvider[name]; ok {
+			return r
+		}
+	}
+
+	for _, tag := range e.Tags {
+		if r, ok := byTag[tag]; ok {
+			return r
+		}
+	}
+
+	return nil
+}
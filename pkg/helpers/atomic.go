@@ -0,0 +1,65 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is a temporary file next to the target file which replaces
+// the target on Commit to never expose a partially written file
+type AtomicFile struct {
+	*os.File
+	target string
+}
+
+// CreateAtomic creates the temporary file for the given target
+func CreateAtomic(target string) (*AtomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %w", err)
+	}
+
+	return &AtomicFile{File: tmp, target: target}, nil
+}
+
+// AtomicWrite passes a temporary file to fn and moves it into place
+// with the given mode when fn succeeded
+func AtomicWrite(target string, mode os.FileMode, fn func(w io.Writer) error) error {
+	f, err := CreateAtomic(target)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	if err = fn(f); err != nil {
+		return err
+	}
+
+	return f.Commit(mode)
+}
+
+// Commit closes the temporary file, sets its mode and moves it into
+// place of the target file
+func (f *AtomicFile) Commit(mode os.FileMode) error {
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	if err := os.Chmod(f.Name(), mode); err != nil { //#nosec:G302 // Mode is chosen by the caller for the kind of file
+		return fmt.Errorf("setting file permissions: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), f.target); err != nil {
+		return fmt.Errorf("moving %s into place: %w", filepath.Base(f.target), err)
+	}
+
+	return nil
+}
+
+// Discard removes the temporary file, after Commit this is a no-op
+func (f *AtomicFile) Discard() {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
@@ -1,4 +1,5 @@
-// Package helpers provides shared domain and list filtering helpers.
+// Package helpers provides shared domain, list filtering and file
+// writing helpers.
 package helpers
 
 import (
@@ -0,0 +1,253 @@
+// Package metrics exposes statistics about the blacklist generation as
+// Prometheus metrics.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+)
+
+const namespace = "named_blacklist"
+
+// Collector holds the metrics of the last blacklist generation
+type Collector struct {
+	registry *prometheus.Registry
+
+	providerBytes       *prometheus.GaugeVec
+	providerDuration    *prometheus.GaugeVec
+	providerEntries     *prometheus.GaugeVec
+	providerExpired     *prometheus.GaugeVec
+	providerExpiring    *prometheus.GaugeVec
+	providerFiltered    *prometheus.GaugeVec
+	providerHTTPStatus  *prometheus.GaugeVec
+	providerLastSuccess *prometheus.GaugeVec
+	providerRejected    *prometheus.GaugeVec
+	providerRejectedBy  *prometheus.GaugeVec
+	providerUp          *prometheus.GaugeVec
+
+	outputEntries     *prometheus.GaugeVec
+	runDuration       prometheus.Gauge
+	runEntries        prometheus.Gauge
+	runLastSuccess    prometheus.Gauge
+	runSuccess        prometheus.Gauge
+	thresholdDrops    prometheus.Gauge
+	whitelistRemovals prometheus.Gauge
+}
+
+// New creates a Collector with all metrics registered in its own
+// registry
+func New() *Collector {
+	providerGauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      name,
+			Help:      help,
+		}, []string{"provider"})
+	}
+
+	runGauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		})
+	}
+
+	c := &Collector{
+		registry: prometheus.NewRegistry(),
+
+		providerBytes:    providerGauge("bytes", "Bytes read from the provider sources"),
+		providerDuration: providerGauge("fetch_duration_seconds", "Duration of fetching and parsing the provider sources"),
+		providerEntries:  providerGauge("entries", "Entries parsed from the provider sources"),
+		providerExpired:  providerGauge("expired_entries", "Manual entries dropped because they expired"),
+		providerExpiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "entry_expiry_timestamp_seconds",
+			Help:      "Expiry time of manual entries expiring within the expiry warning",
+		}, []string{"provider", "domain"}),
+		providerFiltered:    providerGauge("filtered_entries", "Entries not passing the domain filter of the provider"),
+		providerHTTPStatus:  providerGauge("http_status", "HTTP status code of the last URL source"),
+		providerLastSuccess: providerGauge("last_success_timestamp_seconds", "Time of the last successful provider execution"),
+		providerRejected:    providerGauge("rejected_lines", "Lines rejected while parsing the provider sources"),
+		providerRejectedBy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "rejected_lines_by_reason",
+			Help:      "Lines rejected while parsing the provider sources split by reason",
+		}, []string{"provider", "reason"}),
+		providerUp: providerGauge("up", "Whether the last provider execution was successful"),
+
+		outputEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "output",
+			Name:      "entries",
+			Help:      "Entries written into the output",
+		}, []string{"output"}),
+		runDuration:       runGauge("run_duration_seconds", "Duration of the last blacklist generation"),
+		runEntries:        runGauge("entries", "Entries contained in the generated blacklist"),
+		runLastSuccess:    runGauge("last_success_timestamp_seconds", "Time of the last successful blacklist generation"),
+		runSuccess:        runGauge("run_success", "Whether the last blacklist generation was successful"),
+		thresholdDrops:    runGauge("threshold_drops", "Domains dropped because of not reaching min_matches / score_threshold"),
+		whitelistRemovals: runGauge("whitelist_removals", "Domains removed from the blacklist by whitelists"),
+	}
+
+	c.registry.MustRegister(
+		c.providerBytes,
+		c.providerDuration,
+		c.providerEntries,
+		c.providerExpired,
+		c.providerExpiring,
+		c.providerFiltered,
+		c.providerHTTPStatus,
+		c.providerLastSuccess,
+		c.providerRejected,
+		c.providerRejectedBy,
+		c.providerUp,
+		c.outputEntries,
+		c.runDuration,
+		c.runEntries,
+		c.runLastSuccess,
+		c.runSuccess,
+		c.thresholdDrops,
+		c.whitelistRemovals,
+	)
+
+	return c
+}
+
+// Gatherer returns the registry containing the metrics
+func (c *Collector) Gatherer() prometheus.Gatherer { return c.registry }
+
+// RestoreTextfile reads the last success timestamps from a textfile
+// written by a previous run to keep them for providers failing in the
+// next run. A missing file is not an error.
+func (c *Collector) RestoreTextfile(filename string) error {
+	f, err := os.Open(filename) //#nosec:G304 // Intended to read configured metrics file
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("opening textfile: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logrus.WithError(err).Error("closing metrics textfile")
+		}
+	}()
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return fmt.Errorf("parsing textfile: %w", err)
+	}
+
+	if mf, ok := families[prometheus.BuildFQName(namespace, "provider", "last_success_timestamp_seconds")]; ok {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "provider" {
+					c.providerLastSuccess.WithLabelValues(l.GetValue()).Set(m.GetGauge().GetValue())
+				}
+			}
+		}
+	}
+
+	if mf, ok := families[prometheus.BuildFQName(namespace, "", "last_success_timestamp_seconds")]; ok && len(mf.GetMetric()) > 0 {
+		c.runLastSuccess.Set(mf.GetMetric()[0].GetGauge().GetValue())
+	}
+
+	return nil
+}
+
+// Update replaces the metrics with the values of the given run. The
+// outputs map the output name to the number of entries written into
+// it and are only taken into account for successful runs. Last success
+// timestamps are kept from previous updates when the execution failed.
+func (c *Collector) Update(stats generator.RunStats, runErr error, outputs map[string]int, now time.Time) {
+	for _, vec := range []*prometheus.GaugeVec{
+		c.providerBytes,
+		c.providerDuration,
+		c.providerEntries,
+		c.providerExpired,
+		c.providerExpiring,
+		c.providerFiltered,
+		c.providerHTTPStatus,
+		c.providerRejected,
+		c.providerRejectedBy,
+		c.providerUp,
+	} {
+		vec.Reset()
+	}
+
+	for _, p := range stats.Providers {
+		if p.Name == "" {
+			// Provider was not executed
+			continue
+		}
+
+		c.providerBytes.WithLabelValues(p.Name).Set(float64(p.Bytes))
+		c.providerDuration.WithLabelValues(p.Name).Set(p.Duration.Seconds())
+		c.providerEntries.WithLabelValues(p.Name).Set(float64(p.Entries))
+		c.providerExpired.WithLabelValues(p.Name).Set(float64(p.Expired))
+		c.providerFiltered.WithLabelValues(p.Name).Set(float64(p.Filtered))
+		c.providerRejected.WithLabelValues(p.Name).Set(float64(p.Rejected))
+		for reason, n := range p.RejectedByReason {
+			c.providerRejectedBy.WithLabelValues(p.Name, string(reason)).Set(float64(n))
+		}
+
+		for _, e := range p.Expiring {
+			c.providerExpiring.WithLabelValues(p.Name, e.Domain).Set(float64(e.Expires.Unix()))
+		}
+
+		if p.HTTPStatus != 0 {
+			c.providerHTTPStatus.WithLabelValues(p.Name).Set(float64(p.HTTPStatus))
+		}
+
+		if p.Error != nil {
+			c.providerUp.WithLabelValues(p.Name).Set(0)
+			continue
+		}
+
+		c.providerUp.WithLabelValues(p.Name).Set(1)
+		c.providerLastSuccess.WithLabelValues(p.Name).Set(float64(now.Unix()))
+	}
+
+	c.runDuration.Set(stats.Duration.Seconds())
+
+	if runErr != nil {
+		c.runSuccess.Set(0)
+		return
+	}
+
+	c.outputEntries.Reset()
+	for name, n := range outputs {
+		c.outputEntries.WithLabelValues(name).Set(float64(n))
+	}
+
+	c.runEntries.Set(float64(stats.Entries))
+	c.runLastSuccess.Set(float64(now.Unix()))
+	c.runSuccess.Set(1)
+	c.thresholdDrops.Set(float64(stats.ThresholdDrops))
+	c.whitelistRemovals.Set(float64(stats.WhitelistRemovals))
+}
+
+// WriteTextfile writes the metrics in a format to be read by the
+// textfile collector of the node-exporter
+func (c *Collector) WriteTextfile(filename string) error {
+	if err := prometheus.WriteToTextfile(filename, c.registry); err != nil {
+		return fmt.Errorf("writing textfile: %w", err)
+	}
+
+	return nil
+}
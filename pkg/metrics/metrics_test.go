@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	var (
+		c    = New()
+		file = filepath.Join(t.TempDir(), "named_blacklist.prom")
+		now  = time.Unix(1700000000, 0)
+	)
+
+	c.Update(generator.RunStats{
+		Duration: 2 * time.Second,
+		Entries:  10,
+		Providers: []generator.ProviderStats{
+			{Name: "Feed", Stats: provider.Stats{Bytes: 512, Entries: 12, Filtered: 4, HTTPStatus: 200, Rejected: 3, RejectedByReason: map[provider.RejectReason]int{
+				provider.RejectReasonInvalidDomain: 2,
+				provider.RejectReasonIPAddress:     1,
+			}}},
+			{Name: "Broken", Stats: provider.Stats{HTTPStatus: 503}, Error: errors.New("unexected status 503")},
+			{Name: "Manual", Stats: provider.Stats{Entries: 2, Expired: 1, Expiring: []provider.ExpiringEntry{
+				{Domain: "temp.example.com", Expires: now.Add(time.Hour)},
+			}}},
+		},
+		ThresholdDrops:    1,
+		WhitelistRemovals: 2,
+	}, nil, map[string]int{"-": 10}, now)
+
+	require.NoError(t, c.WriteTextfile(file))
+
+	content, err := os.ReadFile(file) //#nosec:G304 // Reading test file
+	require.NoError(t, err)
+
+	for _, line := range []string{
+		`named_blacklist_entries 10`,
+		`named_blacklist_output_entries{output="-"} 10`,
+		`named_blacklist_provider_bytes{provider="Feed"} 512`,
+		`named_blacklist_provider_entries{provider="Feed"} 12`,
+		`named_blacklist_provider_entry_expiry_timestamp_seconds{domain="temp.example.com",provider="Manual"} 1.7000036e+09`,
+		`named_blacklist_provider_expired_entries{provider="Feed"} 0`,
+		`named_blacklist_provider_expired_entries{provider="Manual"} 1`,
+		`named_blacklist_provider_filtered_entries{provider="Feed"} 4`,
+		`named_blacklist_provider_http_status{provider="Broken"} 503`,
+		`named_blacklist_provider_last_success_timestamp_seconds{provider="Feed"} 1.7e+09`,
+		`named_blacklist_provider_rejected_lines{provider="Feed"} 3`,
+		`named_blacklist_provider_rejected_lines_by_reason{provider="Feed",reason="invalid_domain"} 2`,
+		`named_blacklist_provider_rejected_lines_by_reason{provider="Feed",reason="ip_address"} 1`,
+		`named_blacklist_provider_up{provider="Broken"} 0`,
+		`named_blacklist_provider_up{provider="Feed"} 1`,
+		`named_blacklist_run_success 1`,
+		`named_blacklist_threshold_drops 1`,
+		`named_blacklist_whitelist_removals 2`,
+	} {
+		assert.Contains(t, string(content), line+"\n")
+	}
+
+	assert.NotContains(t, string(content), `named_blacklist_provider_last_success_timestamp_seconds{provider="Broken"}`)
+}
+
+func TestRestoreTextfileKeepsLastSuccess(t *testing.T) {
+	var (
+		file  = filepath.Join(t.TempDir(), "named_blacklist.prom")
+		first = time.Unix(1700000000, 0)
+	)
+
+	// Nothing to restore on the first run
+	c := New()
+	require.NoError(t, c.RestoreTextfile(file))
+
+	c.Update(generator.RunStats{
+		Providers: []generator.ProviderStats{{Name: "Feed"}, {Name: "Other"}},
+	}, nil, map[string]int{"-": 10}, first)
+	require.NoError(t, c.WriteTextfile(file))
+
+	// Each run uses a new collector in textfile mode
+	c = New()
+	require.NoError(t, c.RestoreTextfile(file))
+
+	c.Update(generator.RunStats{
+		Providers: []generator.ProviderStats{{Name: "Feed", Error: errors.New("unexected status 503")}, {Name: "Other"}},
+	}, errors.New("generating blacklist"), nil, first.Add(time.Hour))
+	require.NoError(t, c.WriteTextfile(file))
+
+	content, err := os.ReadFile(file) //#nosec:G304 // Reading test file
+	require.NoError(t, err)
+
+	for _, line := range []string{
+		`named_blacklist_last_success_timestamp_seconds 1.7e+09`,
+		`named_blacklist_provider_last_success_timestamp_seconds{provider="Feed"} 1.7e+09`,
+		`named_blacklist_provider_last_success_timestamp_seconds{provider="Other"} 1.7000036e+09`,
+		`named_blacklist_provider_up{provider="Feed"} 0`,
+		`named_blacklist_run_success 0`,
+	} {
+		assert.Contains(t, string(content), line+"\n")
+	}
+}
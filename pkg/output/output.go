@@ -0,0 +1,94 @@
+// Package output renders the compiled blacklist into the configured
+// output files.
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+const stdout = "-"
+
+// Filter returns the entries matching the tag selection and score
+// threshold of the given output definition
+func Filter(def config.OutputDefinition, blacklist []provider.Entry) []provider.Entry {
+	if len(def.Tags) == 0 && len(def.ExcludeTags) == 0 && def.ScoreThreshold == 0 {
+		return blacklist
+	}
+
+	var out []provider.Entry
+	for _, e := range blacklist {
+		if len(def.Tags) > 0 && !hasAnyTag(e, def.Tags) {
+			continue
+		}
+
+		if hasAnyTag(e, def.ExcludeTags) {
+			continue
+		}
+
+		if e.Score < def.ScoreThreshold {
+			continue
+		}
+
+		out = append(out, e)
+	}
+
+	return out
+}
+
+// Name returns a human readable name of the output to use in logs and
+// metrics
+func Name(def config.OutputDefinition) string {
+	if def.File == "" {
+		return stdout
+	}
+
+	return def.File
+}
+
+// Write renders the entries matching the output definition into its
+// file (or stdout) and returns the number of entries written. Files are
+// replaced atomically to never expose a partially written zone to the
+// nameserver.
+func Write(def config.OutputDefinition, blacklist []provider.Entry) (n int, err error) {
+	entries := Filter(def, blacklist)
+
+	logrus.WithFields(logrus.Fields{
+		"file":       def.File,
+		"no_entries": len(entries),
+	}).Debug("rendering output")
+
+	if Name(def) == stdout {
+		return len(entries), render(os.Stdout, def, entries)
+	}
+
+	if err = helpers.AtomicWrite(def.File, 0o644, func(w io.Writer) error { //#nosec:G302 // Zone files need to be readable by the nameserver
+		return render(w, def, entries)
+	}); err != nil {
+		return 0, fmt.Errorf("writing output: %w", err)
+	}
+
+	return len(entries), nil
+}
+
+func hasAnyTag(e provider.Entry, tags []string) bool {
+	return slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(e.Tags, tag) })
+}
+
+func render(w io.Writer, def config.OutputDefinition, entries []provider.Entry) error {
+	if err := def.CompiledTemplate.Execute(w, map[string]any{
+		"blacklist": entries,
+	}); err != nil {
+		return fmt.Errorf("rendering blacklist: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+var testBlacklist = []provider.Entry{
+	{Domain: "ads.example.com", Details: &provider.Details{Score: 1, Tags: []string{"ads"}}},
+	{Domain: "casino.example.com", Details: &provider.Details{Score: 2, Tags: []string{"gambling"}}},
+	{Domain: "malware.example.com", Details: &provider.Details{Score: 5, Tags: []string{"ads", "malware"}}},
+	{Domain: "untagged.example.com", Details: &provider.Details{Score: 1}},
+}
+
+func TestFilterByTags(t *testing.T) {
+	domains := func(entries []provider.Entry) (out []string) {
+		for _, e := range entries {
+			out = append(out, e.Domain)
+		}
+		return out
+	}
+
+	assert.Equal(t,
+		[]string{"ads.example.com", "casino.example.com", "malware.example.com", "untagged.example.com"},
+		domains(Filter(config.OutputDefinition{}, testBlacklist)))
+
+	assert.Equal(t,
+		[]string{"ads.example.com", "casino.example.com", "malware.example.com"},
+		domains(Filter(config.OutputDefinition{Tags: []string{"ads", "gambling"}}, testBlacklist)))
+
+	assert.Equal(t,
+		[]string{"ads.example.com"},
+		domains(Filter(config.OutputDefinition{Tags: []string{"ads"}, ExcludeTags: []string{"malware"}}, testBlacklist)))
+
+	assert.Equal(t,
+		[]string{"ads.example.com", "untagged.example.com"},
+		domains(Filter(config.OutputDefinition{ExcludeTags: []string{"gambling", "malware"}}, testBlacklist)))
+
+	assert.Equal(t,
+		[]string{"casino.example.com", "malware.example.com"},
+		domains(Filter(config.OutputDefinition{ScoreThreshold: 2}, testBlacklist)))
+}
+
+func TestWriteToFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kids.zone")
+
+	n, err := Write(config.OutputDefinition{
+		File: file,
+		Tags: []string{"gambling"},
+		CompiledTemplate: template.Must(template.New("test").Parse(
+			`{{ range .blacklist }}{{ .Domain }} CNAME . ; {{ range .Tags }}{{ . }}{{ end }}{{ "\n" }}{{ end }}`,
+		)),
+	}, testBlacklist)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	content, err := os.ReadFile(file) //#nosec:G304 // Reading test file
+	require.NoError(t, err)
+	assert.Equal(t, "casino.example.com CNAME . ; gambling\n", string(content))
+
+	files, err := os.ReadDir(filepath.Dir(file))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary file should be gone")
+}
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+type (
+	// fieldFilter represents a parsed filter expression from the
+	// FieldExtraction of a provider definition
+	fieldFilter struct {
+		config.FieldFilter
+	}
+
+	// fieldGetter retrieves all values of the given field from a record
+	fieldGetter func(field string) []string
+)
+
+func parseFieldFilters(exprs []string) ([]fieldFilter, error) {
+	filters := make([]fieldFilter, 0, len(exprs))
+
+	for _, expr := range exprs {
+		f, err := config.ParseFieldFilter(expr)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // Error already describes the expression
+		}
+
+		filters = append(filters, fieldFilter{f})
+	}
+
+	return filters, nil
+}
+
+// matches checks whether any of the values of the field satisfies the
+// filter (or none of them does for negated operators)
+func (f fieldFilter) matches(get fieldGetter) bool {
+	values := get(f.Field)
+
+	switch f.Op {
+	case config.FieldFilterOpEqual:
+		for _, v := range values {
+			if v == f.Value {
+				return true
+			}
+		}
+		return false
+
+	case config.FieldFilterOpNotEqual:
+		for _, v := range values {
+			if v == f.Value {
+				return false
+			}
+		}
+		return true
+
+	case config.FieldFilterOpMatch:
+		for _, v := range values {
+			if f.Regex.MatchString(v) {
+				return true
+			}
+		}
+		return false
+
+	case config.FieldFilterOpNotMatch:
+		for _, v := range values {
+			if f.Regex.MatchString(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func matchesAllFilters(filters []fieldFilter, get fieldGetter) bool {
+	for _, f := range filters {
+		if !f.matches(get) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fieldComment builds the entry comment from the provider name and the
+// configured comment fields in the same format the hosts-file provider
+// uses for inline comments
+func fieldComment(d config.ProviderDefinition, src config.Source, get fieldGetter) string {
+	comment := fmt.Sprintf("%q", sourceComment(d, src))
+
+	for _, field := range d.Fields.Comments {
+		values := get(field)
+		if len(values) == 0 {
+			continue
+		}
+
+		comment = fmt.Sprintf("%s, %s: %q", comment, field, strings.Join(values, ", "))
+	}
+
+	return comment
+}
+
+// jsonLookup resolves a dot-separated path against a decoded JSON value.
+// A `*` segment expands to all elements of an array or all values of an
+// object, numeric segments address array elements.
+func jsonLookup(v any, path string) []any {
+	if path == "" {
+		return []any{v}
+	}
+
+	segment, rest, _ := strings.Cut(path, ".")
+
+	var next []any
+	switch val := v.(type) {
+	case map[string]any:
+		if segment == "*" {
+			// Keep the order stable to get reproducible comments
+			keys := make([]string, 0, len(val))
+			for key := range val {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				next = append(next, val[key])
+			}
+		} else if child, ok := val[segment]; ok {
+			next = append(next, child)
+		}
+
+	case []any:
+		if segment == "*" {
+			next = append(next, val...)
+		} else if idx, err := strconv.Atoi(segment); err == nil && idx >= 0 && idx < len(val) {
+			next = append(next, val[idx])
+		}
+	}
+
+	var out []any
+	for _, child := range next {
+		out = append(out, jsonLookup(child, rest)...)
+	}
+
+	return out
+}
+
+// jsonStrings converts the scalar values found at the given path into
+// strings, arrays found at the end of the path are flattened
+func jsonStrings(v any, path string) (out []string) {
+	for _, val := range jsonLookup(v, path) {
+		switch sv := val.(type) {
+		case nil, map[string]any:
+			continue
+
+		case []any:
+			for _, item := range sv {
+				out = append(out, jsonStrings(item, "")...)
+			}
+
+		case string:
+			out = append(out, sv)
+
+		case json.Number:
+			out = append(out, sv.String())
+
+		default:
+			out = append(out, fmt.Sprint(sv))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+)
+
+type (
+	// domainFilter applies the filter and transforms of a provider
+	// definition to the normalized domains of its entries
+	domainFilter struct {
+		exclude    domainRules
+		include    domainRules
+		maxLabels  int
+		transforms []func(string) string
+	}
+
+	domainRules struct {
+		regexps  []*regexp.Regexp
+		suffixes []string
+	}
+)
+
+func newDomainFilter(d config.ProviderDefinition) (f domainFilter, err error) {
+	if f.include, err = newDomainRules(d.Filter.Include); err != nil {
+		return f, fmt.Errorf("parsing include filter: %w", err)
+	}
+
+	if f.exclude, err = newDomainRules(d.Filter.Exclude); err != nil {
+		return f, fmt.Errorf("parsing exclude filter: %w", err)
+	}
+
+	f.maxLabels = d.Filter.MaxLabels
+
+	for _, t := range d.Transforms {
+		switch t {
+		case config.DomainTransformETLDPlusOne:
+			f.transforms = append(f.transforms, fqdn.RegistrableDomain)
+		case config.DomainTransformStripWWW:
+			f.transforms = append(f.transforms, fqdn.StripWWW)
+		default:
+			return f, fmt.Errorf("unknown transform %q", t)
+		}
+	}
+
+	return f, nil
+}
+
+// matches checks whether the domain passes the filter
+func (f domainFilter) matches(domain string) bool {
+	if f.maxLabels > 0 && strings.Count(domain, ".")+1 > f.maxLabels {
+		return false
+	}
+
+	if !f.include.empty() && !f.include.matches(domain) {
+		return false
+	}
+
+	return !f.exclude.matches(domain)
+}
+
+// transform applies the transforms in the order they were configured
+func (f domainFilter) transform(domain string) string {
+	for _, t := range f.transforms {
+		domain = t(domain)
+	}
+
+	return domain
+}
+
+func newDomainRules(r config.DomainRules) (rules domainRules, err error) {
+	for _, expr := range r.Regex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rules, fmt.Errorf("compiling regex %q: %w", expr, err)
+		}
+		rules.regexps = append(rules.regexps, re)
+	}
+
+	// A TLD is matched the same way as a suffix consisting of one label
+	for _, suffix := range append(append([]string{}, r.Suffix...), r.TLD...) {
+		suffix = strings.TrimPrefix(strings.TrimPrefix(suffix, "*"), ".")
+		if suffix == "" {
+			return rules, errors.New("empty suffix")
+		}
+
+		normalized, err := fqdn.Normalize(suffix, false)
+		if err != nil {
+			return rules, fmt.Errorf("normalizing suffix %q: %w", suffix, err)
+		}
+		rules.suffixes = append(rules.suffixes, normalized)
+	}
+
+	return rules, nil
+}
+
+func (r domainRules) empty() bool {
+	return len(r.regexps) == 0 && len(r.suffixes) == 0
+}
+
+func (r domainRules) matches(domain string) bool {
+	for _, suffix := range r.suffixes {
+		if hasDomainSuffix(domain, suffix) {
+			return true
+		}
+	}
+
+	for _, re := range r.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasDomainSuffix checks whether the domain equals the suffix or is a
+// subdomain of it
+func hasDomainSuffix(domain, suffix string) bool {
+	if !strings.HasSuffix(domain, suffix) {
+		return false
+	}
+
+	return len(domain) == len(suffix) || domain[len(domain)-len(suffix)-1] == '.'
+}
@@ -2,49 +2,325 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
 )
 
 type (
-	// Entry represents an entry of the black-/whitelist including
-	// comments where it was found
+	// Details contains the values of an entry besides its domain: the
+	// comments where it was found, the providers listing it with their
+	// tags and the sum of their weights. When state is kept between
+	// runs it also carries the time the entry was first and last seen.
+	// Redirect is set when the domain should resolve to a walled-garden
+	// instead of NXDOMAIN and must not be modified. Entries having the
+	// same values share their Details which therefore must not be
+	// modified in place.
+	Details struct {
+		Comments  []string
+		FirstSeen time.Time
+		LastSeen  time.Time
+		Providers []string
+		Redirect  *config.Redirect
+		Score     float64
+		Tags      []string
+	}
+
+	// Entry represents an entry of the black-/whitelist. Domain is the
+	// canonical form of the domain (see fqdn.Normalize), Original the
+	// form it was listed in by the (first) provider. Entries only carry
+	// a reference to their Details to keep lists having millions of
+	// entries small, the fields of the Details are accessible through
+	// the entry (i.e. `.Comments` within templates). Providers may emit
+	// entries without Details, StreamDomainList and the generator always
+	// pass on entries having Details.
 	Entry struct {
 		Domain   string
-		Comments []string
+		Original string
+		*Details
+	}
+
+	// Env contains the environment a provider is executed in. When
+	// executed through GetDomainList all fields are set.
+	Env struct {
+		config.SourceOptions
+		// OnReject receives every rejected line / record when set. It is
+		// called from the providers executed at the same time, the
+		// function needs to synchronize itself.
+		OnReject func(Rejection)
+		// Stats receives information about the execution, providers
+		// count rejected lines / records in it through Rejecter
+		Stats *Stats
+		// StripWWW removes a leading "www." label while normalizing the
+		// domains of the entries
+		StripWWW bool
 	}
 
 	// Provider represents a source of domain Entries
 	Provider interface {
-		// GetDomainList extracts domain entries from the configured provider source.
-		GetDomainList(appVersion string, pd config.ProviderDefinition) ([]Entry, error)
+		// GetDomainList extracts domain entries from the configured provider
+		// source, passes them to emit one after another as soon as they
+		// are extracted and counts rejected lines in the stats of the env.
+		GetDomainList(env Env, pd config.ProviderDefinition, emit func(Entry)) error
+	}
+
+	// Stats contains information about a single provider execution
+	Stats struct {
+		// Bytes read from the sources of the provider
+		Bytes int64
+		// Duration of fetching and parsing the sources
+		Duration time.Duration
+		// Entries returned by the provider
+		Entries int
+		// Expired counts manual entries dropped because they expired
+		Expired int
+		// Filtered counts entries not passing the filter of the provider
+		Filtered int
+		// Expiring lists manual entries expiring within the expiry
+		// warning of the provider
+		Expiring []ExpiringEntry
+		// HTTPStatus of the last URL source, zero for other sources
+		HTTPStatus int
+		// Rejected lines / records not resulting in an entry
+		Rejected int
+		// RejectedByReason splits the rejected lines by the reason they
+		// were rejected for
+		RejectedByReason map[RejectReason]int
+	}
+
+	// ExpiringEntry describes a manual entry expiring soon
+	ExpiringEntry struct {
+		Domain  string    `json:"domain"`
+		Expires time.Time `json:"expires"`
+		Reason  string    `json:"reason,omitempty"`
+		Ticket  string    `json:"ticket,omitempty"`
+	}
+
+	// Rejection describes a line / record of a source not resulting in
+	// an entry
+	Rejection struct {
+		// Line is the number of the line (or the record for JSON sources)
+		// within the source, zero for entries rejected after parsing
+		Line     int    `json:"line,omitempty"`
+		Provider string `json:"provider"`
+		// Raw is the line / record as read from the source
+		Raw    string       `json:"raw"`
+		Reason RejectReason `json:"reason"`
+		// Source names the source for definitions having multiple sources
+		Source string `json:"source,omitempty"`
+	}
+
+	// RejectReason describes why a line / record of a source did not
+	// result in an entry
+	RejectReason string
+
+	countingReader struct {
+		io.Reader
+		stats *Stats
 	}
 )
 
+// List of reasons for rejecting lines / records
+const (
+	RejectReasonGenericBlacklist  RejectReason = "generic_blacklist"
+	RejectReasonInvalidDomain     RejectReason = "invalid_domain"
+	RejectReasonInvalidFormat     RejectReason = "invalid_format"
+	RejectReasonInvalidURL        RejectReason = "invalid_url"
+	RejectReasonIPAddress         RejectReason = "ip_address"
+	RejectReasonNoSinkhole        RejectReason = "no_sinkhole"
+	RejectReasonTooFewURLs        RejectReason = "too_few_urls"
+	RejectReasonUnsupportedOption RejectReason = "unsupported_option"
+	RejectReasonUnsupportedRule   RejectReason = "unsupported_rule"
+	RejectReasonWrongMode         RejectReason = "wrong_mode"
+)
+
 var (
 	providerRegistry     = make(map[config.ProviderType]Provider)
-	providerRegistryLock sync.Mutex
+	providerRegistryLock sync.RWMutex
 )
 
 // GetDomainList executes the provider given through the passed definition
-func GetDomainList(appVersion string, p config.ProviderDefinition) (entries []Entry, err error) {
+// and returns all of its entries. Use StreamDomainList to process large
+// lists without keeping all entries in memory.
+func GetDomainList(env Env, p config.ProviderDefinition) (entries []Entry, err error) {
+	if err = StreamDomainList(env, p, func(e Entry) { entries = append(entries, e) }); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// StreamDomainList executes the provider given through the passed
+// definition, passes its entries to emit and records information about
+// the execution in the stats of the env. The domains of the entries are
+// normalized, filtered and transformed as configured in the definition
+// before being passed on, entries failing normalization are rejected,
+// entries not passing the filter are counted as filtered. Entries
+// without Details get empty Details to make their fields accessible.
+// Unset fields of the env are filled with their defaults.
+func StreamDomainList(env Env, p config.ProviderDefinition, emit func(Entry)) error {
+	providerRegistryLock.RLock()
 	pro, ok := providerRegistry[p.Type]
+	providerRegistryLock.RUnlock()
+
 	if !ok {
-		return nil, fmt.Errorf("unknown provider type %q", p.Type)
+		return fmt.Errorf("unknown provider type %q", p.Type)
 	}
 
-	if entries, err = pro.GetDomainList(appVersion, p); err != nil {
-		return nil, fmt.Errorf("getting domain-list: %w", err)
+	env.SourceOptions = env.WithDefaults()
+	if env.Stats == nil {
+		env.Stats = new(Stats)
 	}
 
-	return entries, nil
+	start := time.Now()
+	defer func() { env.Stats.Duration = time.Since(start) }()
+
+	filter, err := newDomainFilter(p)
+	if err != nil {
+		return fmt.Errorf("creating domain filter: %w", err)
+	}
+
+	var (
+		// Entries are rejected after parsing, the line is not known anymore
+		reject = env.Rejecter(p, config.Source{})
+		// Shared by all entries the provider emits without details
+		noDetails = new(Details)
+	)
+
+	if err := pro.GetDomainList(env, p, func(e Entry) {
+		domain, err := fqdn.Normalize(e.Domain, env.StripWWW)
+		if err != nil {
+			reject(0, e.Domain, RejectReasonInvalidDomain)
+			return
+		}
+
+		if !filter.matches(domain) {
+			// Filtered entries are intended to be dropped: they are neither
+			// rejected nor reported as such
+			env.Stats.Filtered++
+			return
+		}
+
+		if e.Original == "" {
+			e.Original = e.Domain
+		}
+		if e.Details == nil {
+			e.Details = noDetails
+		}
+		e.Domain = filter.transform(domain)
+
+		env.Stats.Entries++
+		emit(e)
+	}); err != nil {
+		return fmt.Errorf("getting domain-list: %w", err)
+	}
+
+	return nil
 }
 
-func registerProvider(t config.ProviderType, p Provider) {
+// Register makes the provider available for definitions of the given
+// type and marks the type as known to the config validation. Registering
+// a type twice replaces the previous provider.
+func Register(t config.ProviderType, p Provider) {
 	providerRegistryLock.Lock()
 	defer providerRegistryLock.Unlock()
 
 	providerRegistry[t] = p
+	config.RegisterProviderType(t)
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.stats.Bytes += int64(n)
+	return n, err //nolint:wrapcheck // Must not wrap io.EOF
+}
+
+// Rejecter returns a function to report the rejected lines / records of
+// the given source: they are counted in the stats, logged and passed to
+// OnReject
+func (e Env) Rejecter(d config.ProviderDefinition, src config.Source) func(line int, raw string, reason RejectReason) {
+	logger := e.Logger.WithField("provider", d.Name)
+	if src.Name != "" {
+		logger = logger.WithField("source", src.Name)
+	}
+
+	return func(line int, raw string, reason RejectReason) {
+		e.Stats.reject(reason)
+		logger.WithFields(logrus.Fields{"line": line, "raw": raw, "reason": reason}).Debug("rejecting line")
+
+		if e.OnReject != nil {
+			e.OnReject(Rejection{Line: line, Provider: d.Name, Raw: raw, Reason: reason, Source: src.Name})
+		}
+	}
+}
+
+// reject counts a rejected line / record for the given reason
+func (s *Stats) reject(reason RejectReason) {
+	if s.RejectedByReason == nil {
+		s.RejectedByReason = make(map[RejectReason]int)
+	}
+
+	s.Rejected++
+	s.RejectedByReason[reason]++
+}
+
+// readSources opens the sources of the given definition one after
+// another and passes their content to the given function
+func readSources(env Env, d config.ProviderDefinition, fn func(src config.Source, r io.Reader) error) error {
+	sources, err := d.GetSources(env.SourceOptions)
+	if err != nil {
+		return fmt.Errorf("getting sources: %w", err)
+	}
+
+	for _, src := range sources {
+		if err = readSource(env, src, fn); err != nil {
+			if src.Name != "" {
+				return fmt.Errorf("reading %q: %w", src.Name, err)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSource(env Env, src config.Source, fn func(src config.Source, r io.Reader) error) error {
+	r, err := src.Open()
+
+	var statusErr config.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		env.Stats.HTTPStatus = statusErr.StatusCode
+	}
+	if sc, ok := r.(interface{ StatusCode() int }); ok {
+		env.Stats.HTTPStatus = sc.StatusCode()
+	}
+
+	if err != nil {
+		return fmt.Errorf("getting source content: %w", err)
+	}
+
+	defer func() {
+		if err := r.Close(); err != nil {
+			env.Logger.WithError(err).Error("closing domain-list")
+		}
+	}()
+
+	return fn(src, countingReader{Reader: r, stats: env.Stats})
+}
+
+// sourceComment names the provider and, for definitions having multiple
+// sources, the source the entry was found in
+func sourceComment(d config.ProviderDefinition, src config.Source) string {
+	if src.Name == "" {
+		return d.Name
+	}
+
+	return fmt.Sprintf("%s (%s)", d.Name, src.Name)
 }
@@ -3,10 +3,9 @@ package provider
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"strings"
 
-	"github.com/sirupsen/logrus"
-
 	"github.com/Luzifer/named-blacklist/pkg/config"
 	"github.com/Luzifer/named-blacklist/pkg/fqdn"
 	"github.com/Luzifer/named-blacklist/pkg/helpers"
@@ -15,72 +14,70 @@ import (
 type providerAdblockPlus struct{}
 
 func init() {
-	registerProvider("adblock-plus", providerAdblockPlus{})
+	Register(config.ProviderTypeAdblockPlus, providerAdblockPlus{})
 }
 
-func (providerAdblockPlus) GetDomainList(appVersion string, d config.ProviderDefinition) ([]Entry, error) {
-	r, err := d.GetContent(appVersion)
-	if err != nil {
-		return nil, fmt.Errorf("getting source content: %w", err)
-	}
-
-	defer func() {
-		if err := r.Close(); err != nil {
-			logrus.WithError(err).Error("closing domain-list")
-		}
-	}()
-
-	var (
-		entries []Entry
-		logger  = logrus.WithField("provider", d.Name)
-		scanner = bufio.NewScanner(r)
-	)
-
-nextLine:
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if helpers.LineIsComment(line) {
-			continue
+func (providerAdblockPlus) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			// All entries of the source share the same comment
+			details = &Details{Comments: []string{sourceComment(d, src)}}
+			lineNo  int
+			reject  = env.Rejecter(d, src)
+			scanner = bufio.NewScanner(r)
+		)
+
+	nextLine:
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+
+			if helpers.LineIsComment(line) {
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(line, "@@") && d.Action == config.ProviderActionBlacklist:
+				// Whitelist-entry and blacklist-mode, skip that one
+				reject(lineNo, scanner.Text(), RejectReasonWrongMode)
+				continue nextLine
+
+			case strings.HasPrefix(line, "||") && d.Action == config.ProviderActionWhitelist:
+				// Blacklist-entry and whitelist-mode, skip that one
+				reject(lineNo, scanner.Text(), RejectReasonWrongMode)
+				continue nextLine
+
+			case strings.HasPrefix(line, "|htt"):
+				// We do not support that format
+				reject(lineNo, scanner.Text(), RejectReasonUnsupportedRule)
+				continue nextLine
+
+			case !strings.HasSuffix(line, "^"):
+				// Propably optioned rule, we don't support that
+				reject(lineNo, scanner.Text(), RejectReasonUnsupportedOption)
+				continue nextLine
+			}
+
+			// Now sanitize the entry
+			domain := strings.TrimSuffix(line, "^")
+			domain = strings.TrimPrefix(domain, "@@")
+			domain = strings.TrimPrefix(domain, "||")
+
+			if !fqdn.IsValidEntry(domain) {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidDomain)
+				continue nextLine
+			}
+
+			emit(Entry{
+				Domain:  domain,
+				Details: details,
+			})
 		}
 
-		switch {
-		case strings.HasPrefix(line, "@@") && d.Action == config.ProviderActionBlacklist:
-			// Whitelist-entry and blacklist-mode, skip that one
-			logger.WithField("domain", line).Debug("skipping: wrong mode")
-			continue nextLine
-
-		case strings.HasPrefix(line, "||") && d.Action == config.ProviderActionWhitelist:
-			// Blacklist-entry and whitelist-mode, skip that one
-			logger.WithField("domain", line).Debug("skipping: wrong mode")
-			continue nextLine
-
-		case strings.HasPrefix(line, "|htt"):
-			// We do not support that format
-			logger.WithField("domain", line).Debug("skipping: unsupported format, schema")
-			continue nextLine
-
-		case !strings.HasSuffix(line, "^"):
-			// Propably optioned rule, we don't support that
-			logger.WithField("domain", line).Debug("skipping: unsupported format, options")
-			continue nextLine
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading adblock-plus list: %w", err)
 		}
 
-		// Now sanitize the entry
-		domain := strings.TrimSuffix(line, "^")
-		domain = strings.TrimPrefix(domain, "@@")
-		domain = strings.TrimPrefix(domain, "||")
-
-		if !fqdn.IsValidEntry(domain) {
-			logger.WithField("domain", domain).Debug("skipping: not a valid domain")
-			continue nextLine
-		}
-
-		entries = append(entries, Entry{
-			Domain:   domain,
-			Comments: []string{d.Name},
-		})
-	}
-
-	return entries, nil
+		return nil
+	})
 }
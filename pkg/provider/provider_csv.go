@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+)
+
+type providerCSV struct{}
+
+func init() {
+	Register(config.ProviderTypeCSV, providerCSV{})
+}
+
+func (providerCSV) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	if d.Fields.Domain == "" {
+		return fmt.Errorf("no domain column specified")
+	}
+
+	filters, err := parseFieldFilters(d.Fields.Filters)
+	if err != nil {
+		return fmt.Errorf("parsing filters: %w", err)
+	}
+
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			columns map[string]int
+			reader  = csv.NewReader(r)
+			reject  = env.Rejecter(d, src)
+		)
+
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		reader.TrimLeadingSpace = true
+
+		if d.Fields.Separator != "" {
+			sep, _ := utf8.DecodeRuneInString(d.Fields.Separator)
+			reader.Comma = sep
+		}
+
+		for {
+			record, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("reading CSV: %w", err)
+			}
+
+			if d.Fields.Header && columns == nil {
+				columns = make(map[string]int, len(record))
+				for i, name := range record {
+					columns[strings.TrimSpace(name)] = i
+				}
+				continue
+			}
+
+			get := func(field string) []string {
+				idx, ok := csvColumnIndex(columns, field)
+				if !ok || idx >= len(record) {
+					return nil
+				}
+				return []string{strings.TrimSpace(record[idx])}
+			}
+
+			if !matchesAllFilters(filters, get) {
+				continue
+			}
+
+			for _, domain := range get(d.Fields.Domain) {
+				if helpers.IsBlacklisted(domain) {
+					rejectRecord(reader, record, reject, RejectReasonGenericBlacklist)
+					continue
+				}
+
+				if !fqdn.IsValidEntry(domain) {
+					rejectRecord(reader, record, reject, RejectReasonInvalidDomain)
+					continue
+				}
+
+				emit(Entry{
+					Domain:  domain,
+					Details: &Details{Comments: []string{fieldComment(d, src, get)}},
+				})
+			}
+		}
+	})
+}
+
+// rejectRecord reports the record last read from the reader
+func rejectRecord(reader *csv.Reader, record []string, reject func(int, string, RejectReason), reason RejectReason) {
+	line, _ := reader.FieldPos(0)
+	reject(line, strings.Join(record, string(reader.Comma)), reason)
+}
+
+// csvColumnIndex resolves a column reference into the zero-based index
+// of the column: names from the header take precedence over indices
+func csvColumnIndex(columns map[string]int, field string) (int, bool) {
+	if idx, ok := columns[field]; ok {
+		return idx, true
+	}
+
+	idx, err := strconv.Atoi(field)
+	if err != nil || idx < 0 {
+		return 0, false
+	}
+
+	return idx, true
+}
@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+func TestCSVProviderWithHeader(t *testing.T) {
+	entries, err := getDomainList(providerCSV{}, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Content: `# Exported feed
+id,host,threat,status
+1,a.example.com,malware_download,online
+2,b.example.com,phishing,online
+3,c.example.com,malware_download,offline
+`,
+		Fields: config.FieldExtraction{
+			Domain:   "host",
+			Comments: []string{"threat"},
+			Filters:  []string{`threat == malware_download`, `status != "offline"`},
+			Header:   true,
+		},
+		Name: "Feed",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "a.example.com", Details: &Details{Comments: []string{`"Feed", threat: "malware_download"`}}},
+	}, entries)
+}
+
+func TestCSVProviderWithColumnIndex(t *testing.T) {
+	entries, err := getDomainList(providerCSV{}, config.ProviderDefinition{
+		Action:  config.ProviderActionBlacklist,
+		Content: "a.example.com;x\n\"b.example.com\";y\nlocalhost;z\n",
+		Fields: config.FieldExtraction{
+			Domain:    "0",
+			Separator: ";",
+		},
+		Name: "Feed",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "a.example.com", Details: &Details{Comments: []string{`"Feed"`}}},
+		{Domain: "b.example.com", Details: &Details{Comments: []string{`"Feed"`}}},
+	}, entries)
+}
@@ -3,10 +3,9 @@ package provider
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"strings"
 
-	"github.com/sirupsen/logrus"
-
 	"github.com/Luzifer/named-blacklist/pkg/config"
 	"github.com/Luzifer/named-blacklist/pkg/fqdn"
 	"github.com/Luzifer/named-blacklist/pkg/helpers"
@@ -15,52 +14,53 @@ import (
 type providerdomainList struct{}
 
 func init() {
-	registerProvider("domain-list", providerdomainList{})
+	Register(config.ProviderTypeDomainList, providerdomainList{})
 }
 
-func (providerdomainList) GetDomainList(appVersion string, d config.ProviderDefinition) ([]Entry, error) {
-	r, err := d.GetContent(appVersion)
-	if err != nil {
-		return nil, fmt.Errorf("getting source content: %w", err)
-	}
-	defer func() {
-		if err := r.Close(); err != nil {
-			logrus.WithError(err).Error("closing domain-list")
-		}
-	}()
+func (providerdomainList) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			// All entries of the source share the same comment
+			details = &Details{Comments: []string{sourceComment(d, src)}}
+			lineNo  int
+			reject  = env.Rejecter(d, src)
+			scanner = bufio.NewScanner(r)
+		)
 
-	logger := logrus.WithField("provider", d.Name)
+		for scanner.Scan() {
+			lineNo++
+			if helpers.LineIsComment(scanner.Text()) {
+				continue
+			}
 
-	var entries []Entry
+			domain, _, _ := strings.Cut(scanner.Text(), "#")
+			domain = strings.TrimSpace(domain)
 
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		if helpers.LineIsComment(scanner.Text()) {
-			continue
-		}
+			if strings.Contains(domain, " ") {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidFormat)
+				continue
+			}
 
-		domain := strings.TrimSpace(strings.Split(scanner.Text(), "#")[0])
+			if helpers.IsBlacklisted(domain) {
+				reject(lineNo, scanner.Text(), RejectReasonGenericBlacklist)
+				continue
+			}
 
-		if strings.Contains(domain, " ") {
-			logger.WithField("line", scanner.Text()).Warn("invalid line found")
-			continue
-		}
+			if !fqdn.IsValidEntry(domain) {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidDomain)
+				continue
+			}
 
-		if helpers.IsBlacklisted(domain) {
-			logger.WithField("domain", domain).Debug("skipping because of blacklist")
-			continue
+			emit(Entry{
+				Domain:  domain,
+				Details: details,
+			})
 		}
 
-		if !fqdn.IsValidEntry(domain) {
-			logger.WithField("domain", domain).Debug("skipping because not a valid domain")
-			continue
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading domain-list: %w", err)
 		}
 
-		entries = append(entries, Entry{
-			Domain:   domain,
-			Comments: []string{d.Name},
-		})
-	}
-
-	return entries, nil
+		return nil
+	})
 }
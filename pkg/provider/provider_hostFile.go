@@ -2,12 +2,13 @@ package provider
 
 import (
 	"bufio"
+	"cmp"
 	"fmt"
-	"regexp"
+	"io"
+	"net/netip"
+	"slices"
 	"strings"
 
-	"github.com/sirupsen/logrus"
-
 	"github.com/Luzifer/named-blacklist/pkg/config"
 	"github.com/Luzifer/named-blacklist/pkg/fqdn"
 	"github.com/Luzifer/named-blacklist/pkg/helpers"
@@ -15,71 +16,93 @@ import (
 
 type providerHostFile struct{}
 
-func init() {
-	registerProvider("hosts-file", providerHostFile{})
+// sinkholeAddresses are the addresses hosts files use to block a host
+var sinkholeAddresses = []netip.Addr{
+	netip.IPv4Unspecified(),
+	netip.AddrFrom4([4]byte{127, 0, 0, 1}),
+	netip.IPv6Unspecified(),
 }
 
-func (providerHostFile) GetDomainList(appVersion string, d config.ProviderDefinition) ([]Entry, error) {
-	r, err := d.GetContent(appVersion)
-	if err != nil {
-		return nil, fmt.Errorf("getting source content: %w", err)
-	}
-
-	defer func() {
-		if err := r.Close(); err != nil {
-			logrus.WithError(err).Error("closing domain-list")
-		}
-	}()
-
-	logger := logrus.WithField("provider", d.Name)
-
-	var (
-		entries []Entry
-		matcher = regexp.MustCompile(`^(?:[0-9.]+|[a-z0-9:]+)\s+([^\s]+)(?:\s+#(.+)|\s+#)?$`)
-	)
-
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if helpers.LineIsComment(line) {
-			continue
-		}
-
-		if !matcher.MatchString(line) {
-			logger.WithField("line", line).Warn("Invalid line found (format)")
-			continue
-		}
-
-		groups := matcher.FindStringSubmatch(line)
-		if len(groups) < 2 {
-			logger.WithField("line", line).Warn("Invalid line found (groups)")
-			continue
-		}
-
-		if helpers.IsBlacklisted(groups[1]) {
-			logger.WithField("domain", groups[1]).Debug("Skipping because of blacklist")
-			continue
-		}
+func init() {
+	Register(config.ProviderTypeHostsFile, providerHostFile{})
+}
 
-		if !fqdn.IsValidEntry(groups[1]) {
-			logger.WithField("domain", groups[1]).Debug("skipping because not a valid domain")
-			continue
+func (providerHostFile) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			lineNo  int
+			reject  = env.Rejecter(d, src)
+			scanner = bufio.NewScanner(r)
+		)
+
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+
+			if helpers.LineIsComment(line) {
+				continue
+			}
+
+			// Comments may follow the hostnames separated by any whitespace
+			line, inlineComment, _ := strings.Cut(line, "#")
+
+			fields := strings.Fields(line)
+			if len(fields) < 2 { //revive:disable-line:add-constant // address and at least one hostname
+				reject(lineNo, scanner.Text(), RejectReasonInvalidFormat)
+				continue
+			}
+
+			addr, err := netip.ParseAddr(fields[0])
+			if err != nil {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidFormat)
+				continue
+			}
+
+			if d.SinkholeOnly && !isSinkhole(addr) {
+				reject(lineNo, scanner.Text(), RejectReasonNoSinkhole)
+				continue
+			}
+
+			comment := fmt.Sprintf("%q", sourceComment(d, src))
+			if inlineComment = strings.TrimSpace(strings.Trim(inlineComment, "#")); inlineComment != "" {
+				comment = fmt.Sprintf("%s, Comment: %q", comment, inlineComment)
+			}
+			details := &Details{Comments: []string{comment}}
+
+			// Lines are rejected once for the first hostname not being
+			// used, the other hostnames of the line are used nevertheless
+			var reason RejectReason
+			for _, host := range fields[1:] {
+				switch {
+				case helpers.IsBlacklisted(host):
+					reason = cmp.Or(reason, RejectReasonGenericBlacklist)
+
+				case !fqdn.IsValidEntry(host):
+					reason = cmp.Or(reason, RejectReasonInvalidDomain)
+
+				default:
+					emit(Entry{
+						Domain:  host,
+						Details: details,
+					})
+				}
+			}
+
+			if reason != "" {
+				reject(lineNo, scanner.Text(), reason)
+			}
 		}
 
-		comment := fmt.Sprintf("%q", d.Name)
-		if len(groups) == 3 && strings.Trim(groups[2], "#") != "" { //revive:disable-line:add-constant // just a group count
-			comment = fmt.Sprintf("%s, Comment: %q",
-				comment,
-				strings.TrimSpace(strings.Trim(groups[2], "#")),
-			)
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading hosts-file: %w", err)
 		}
 
-		entries = append(entries, Entry{
-			Domain:   groups[1],
-			Comments: []string{comment},
-		})
-	}
+		return nil
+	})
+}
 
-	return entries, nil
+// isSinkhole checks whether the address is used to block hosts instead
+// of mapping them to a real address
+func isSinkhole(addr netip.Addr) bool {
+	return slices.Contains(sinkholeAddresses, addr.WithZone("").Unmap())
 }
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+const testHostsFile = `# Header comment
+127.0.0.1 localhost
+0.0.0.0 a.example.com b.example.com	c.example.com
+0.0.0.0	d.example.com	# Tracker
+::	e.example.com#no space
+10.0.0.5 intranet.example.com
+0.0.0.0
+not-an-ip f.example.com
+0.0.0.0 @invalid.example.com localhost g.example.com
+`
+
+func TestHostsFileProviderParsesAllHostnames(t *testing.T) {
+	entries, err := getDomainList(providerHostFile{}, config.ProviderDefinition{
+		Content: testHostsFile,
+		Name:    "Hosts",
+	})
+	require.NoError(t, err)
+
+	var domains []string
+	for _, e := range entries {
+		domains = append(domains, e.Domain)
+	}
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com", "intranet.example.com", "g.example.com"}, domains)
+	assert.Equal(t, []string{`"Hosts", Comment: "Tracker"`}, entries[3].Comments)
+	assert.Equal(t, []string{`"Hosts", Comment: "no space"`}, entries[4].Comments)
+}
+
+func TestHostsFileProviderSinkholeOnly(t *testing.T) {
+	var (
+		env     = testEnv()
+		domains []string
+	)
+
+	require.NoError(t, providerHostFile{}.GetDomainList(env, config.ProviderDefinition{
+		Content:      testHostsFile,
+		Name:         "Hosts",
+		SinkholeOnly: true,
+	}, func(e Entry) { domains = append(domains, e.Domain) }))
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com", "g.example.com"}, domains)
+
+	// Lines having multiple unused hostnames are rejected once
+	assert.Equal(t, 5, env.Stats.Rejected)
+	assert.Equal(t, map[RejectReason]int{
+		RejectReasonGenericBlacklist: 1,
+		RejectReasonInvalidDomain:    1,
+		RejectReasonInvalidFormat:    2,
+		RejectReasonNoSinkhole:       1,
+	}, env.Stats.RejectedByReason)
+}
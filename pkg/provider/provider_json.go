@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+)
+
+type providerJSON struct{}
+
+func init() {
+	Register(config.ProviderTypeJSON, providerJSON{})
+}
+
+func (providerJSON) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	if d.Fields.Domain == "" {
+		return fmt.Errorf("no domain field specified")
+	}
+
+	filters, err := parseFieldFilters(d.Fields.Filters)
+	if err != nil {
+		return fmt.Errorf("parsing filters: %w", err)
+	}
+
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			dec      = json.NewDecoder(r)
+			recordNo int
+			reject   = env.Rejecter(d, src)
+		)
+
+		dec.UseNumber()
+
+		// Loop over the documents to support newline delimited JSON in
+		// addition to a single document
+		for {
+			var doc any
+			if err := dec.Decode(&doc); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("decoding JSON: %w", err)
+			}
+
+			records := jsonLookup(doc, d.Fields.Records)
+			if d.Fields.Records == "" {
+				if list, ok := doc.([]any); ok {
+					records = list
+				}
+			}
+
+			for _, record := range records {
+				recordNo++
+				get := func(field string) []string { return jsonStrings(record, field) }
+
+				if !matchesAllFilters(filters, get) {
+					continue
+				}
+
+				details := &Details{Comments: []string{fieldComment(d, src, get)}}
+
+				for _, domain := range get(d.Fields.Domain) {
+					domain = strings.TrimSpace(domain)
+
+					if helpers.IsBlacklisted(domain) {
+						reject(recordNo, jsonRaw(record), RejectReasonGenericBlacklist)
+						continue
+					}
+
+					if !fqdn.IsValidEntry(domain) {
+						reject(recordNo, jsonRaw(record), RejectReasonInvalidDomain)
+						continue
+					}
+
+					emit(Entry{
+						Domain:  domain,
+						Details: details,
+					})
+				}
+			}
+		}
+	})
+}
+
+// jsonRaw encodes the record to report it as rejected
+func jsonRaw(record any) string {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprint(record)
+	}
+
+	return string(data)
+}
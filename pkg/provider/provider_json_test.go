@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+func TestJSONProviderExtractsFilteredFields(t *testing.T) {
+	entries, err := getDomainList(providerJSON{}, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Content: `{
+  "1": [{"ioc_value": "malware.example.com", "threat_type": "malware_download", "tags": ["elf", "mirai"]}],
+  "2": [{"ioc_value": "c2.example.com", "threat_type": "botnet_cc", "tags": null}],
+  "3": [{"ioc_value": "not a domain", "threat_type": "malware_download"}]
+}`,
+		Fields: config.FieldExtraction{
+			Records:  "*.*",
+			Domain:   "ioc_value",
+			Comments: []string{"threat_type", "tags"},
+			Filters:  []string{`threat_type == "malware_download"`},
+		},
+		Name: "ThreatFox",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "malware.example.com", Details: &Details{Comments: []string{`"ThreatFox", threat_type: "malware_download", tags: "elf, mirai"`}}},
+	}, entries)
+}
+
+func TestJSONProviderReadsNewlineDelimitedDocuments(t *testing.T) {
+	entries, err := getDomainList(providerJSON{}, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Content: `{"host": {"name": "a.example.com"}, "score": 10}
+{"host": {"name": "b.example.com"}, "score": 2}
+`,
+		Fields: config.FieldExtraction{
+			Domain:  "host.name",
+			Filters: []string{`score =~ ^[0-9]{2,}$`},
+		},
+		Name: "NDJSON",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "a.example.com", Details: &Details{Comments: []string{`"NDJSON"`}}},
+	}, entries)
+}
+
+func TestParseFieldFiltersRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		`threat_type`,
+		`threat_type == "unterminated`,
+		`threat_type =~ (`,
+	} {
+		_, err := parseFieldFilters([]string{expr})
+		assert.Error(t, err, expr)
+	}
+}
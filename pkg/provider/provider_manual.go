@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+)
+
+const defaultExpiryWarning = 72 * time.Hour
+
+type providerManual struct {
+	now func() time.Time
+}
+
+func init() {
+	Register(config.ProviderTypeManual, providerManual{now: time.Now})
+}
+
+func (p providerManual) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	var (
+		logger = env.Logger.WithField("provider", d.Name)
+		now    = p.now()
+		reject = env.Rejecter(d, config.Source{})
+		warn   = d.ExpiryWarn
+	)
+
+	if warn == 0 {
+		warn = defaultExpiryWarning
+	}
+
+	for i, me := range d.Entries {
+		domain := strings.TrimSpace(me.Domain)
+		entryLogger := logger.WithFields(logrus.Fields{
+			"domain": domain,
+			"reason": me.Reason,
+			"ticket": me.Ticket,
+		})
+
+		if !fqdn.IsValidEntry(domain) {
+			// A typo in a single entry must not stop the generation
+			reject(i+1, me.Domain, RejectReasonInvalidDomain)
+			continue
+		}
+
+		if !me.Expires.IsZero() {
+			entryLogger = entryLogger.WithField("expires", me.Expires.Format(time.RFC3339))
+
+			switch {
+			case !me.Expires.After(now):
+				entryLogger.Info("dropping expired manual entry")
+				env.Stats.Expired++
+				continue
+
+			case me.Expires.Sub(now) <= warn:
+				entryLogger.Warn("manual entry expires soon")
+				env.Stats.Expiring = append(env.Stats.Expiring, ExpiringEntry{
+					Domain:  domain,
+					Expires: me.Expires,
+					Reason:  me.Reason,
+					Ticket:  me.Ticket,
+				})
+			}
+		}
+
+		emit(Entry{
+			Domain:  domain,
+			Details: &Details{Comments: []string{manualEntryComment(d, me)}},
+		})
+	}
+
+	return nil
+}
+
+// manualEntryComment documents the entry using the same format the
+// hosts-file provider uses for inline comments
+func manualEntryComment(d config.ProviderDefinition, me config.ManualEntry) string {
+	comment := fmt.Sprintf("%q", d.Name)
+
+	for _, field := range []struct{ name, value string }{
+		{"Reason", me.Reason},
+		{"Ticket", me.Ticket},
+	} {
+		if field.value != "" {
+			comment = fmt.Sprintf("%s, %s: %q", comment, field.name, field.value)
+		}
+	}
+
+	if !me.Expires.IsZero() {
+		comment = fmt.Sprintf("%s, Expires: %q", comment, me.Expires.Format(time.RFC3339))
+	}
+
+	return comment
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+func TestManualProviderDropsExpiredEntries(t *testing.T) {
+	var (
+		entries []Entry
+		env     = testEnv()
+		now     = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	)
+
+	err := providerManual{now: func() time.Time { return now }}.GetDomainList(env, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Entries: []config.ManualEntry{
+			{Domain: "permanent.example.com", Reason: "Known malware host"},
+			{Domain: "expired.example.com", Expires: now.Add(-time.Minute), Ticket: "INC-1"},
+			{Domain: "active.example.com", Expires: now.Add(time.Hour), Reason: "Phishing", Ticket: "INC-2"},
+			{Domain: "later.example.com", Expires: now.Add(30 * 24 * time.Hour)},
+		},
+		Name: "Incident Response",
+	}, func(e Entry) { entries = append(entries, e) })
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "permanent.example.com", Details: &Details{Comments: []string{`"Incident Response", Reason: "Known malware host"`}}},
+		{Domain: "active.example.com", Details: &Details{Comments: []string{`"Incident Response", Reason: "Phishing", Ticket: "INC-2", Expires: "2026-10-01T13:00:00Z"`}}},
+		{Domain: "later.example.com", Details: &Details{Comments: []string{`"Incident Response", Expires: "2026-10-31T12:00:00Z"`}}},
+	}, entries)
+
+	assert.Equal(t, 1, env.Stats.Expired)
+	assert.Equal(t, []ExpiringEntry{
+		{Domain: "active.example.com", Expires: now.Add(time.Hour), Reason: "Phishing", Ticket: "INC-2"},
+	}, env.Stats.Expiring)
+}
+
+func TestManualProviderRejectsInvalidDomains(t *testing.T) {
+	var (
+		entries []Entry
+		env     = testEnv()
+	)
+
+	err := providerManual{now: time.Now}.GetDomainList(env, config.ProviderDefinition{
+		Entries: []config.ManualEntry{{Domain: "not a domain"}, {Domain: "valid.example.com"}},
+	}, func(e Entry) { entries = append(entries, e) })
+	require.NoError(t, err)
+
+	require.Len(t, entries, 1)
+	assert.Equal(t, "valid.example.com", entries[0].Domain)
+	assert.Equal(t, 1, env.Stats.Rejected)
+	assert.Equal(t, map[RejectReason]int{RejectReasonInvalidDomain: 1}, env.Stats.RejectedByReason)
+}
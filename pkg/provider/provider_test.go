@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+type (
+	providerFunc func(env Env, d config.ProviderDefinition, emit func(Entry)) error
+	testProvider struct{}
+)
+
+func (f providerFunc) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	return f(env, d, emit)
+}
+
+func (testProvider) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	return readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			lineNo  int
+			reject  = env.Rejecter(d, src)
+			scanner = bufio.NewScanner(r)
+		)
+
+		for scanner.Scan() {
+			lineNo++
+			if !strings.HasSuffix(scanner.Text(), ".test") {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidDomain)
+				continue
+			}
+			emit(Entry{Domain: scanner.Text(), Details: &Details{Comments: []string{d.Name}}})
+		}
+		return scanner.Err()
+	})
+}
+
+func TestRegisterCustomProvider(t *testing.T) {
+	Register("test-only", testProvider{})
+
+	var (
+		rejections []Rejection
+		stats      Stats
+	)
+
+	entries, err := GetDomainList(Env{OnReject: func(r Rejection) { rejections = append(rejections, r) }, Stats: &stats}, config.ProviderDefinition{
+		Content: "a.test\nb.example.com\n",
+		Name:    "Custom",
+		Type:    "test-only",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Entry{{Domain: "a.test", Original: "a.test", Details: &Details{Comments: []string{"Custom"}}}}, entries)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, map[RejectReason]int{RejectReasonInvalidDomain: 1}, stats.RejectedByReason)
+	assert.Equal(t, int64(21), stats.Bytes)
+	assert.Equal(t, []Rejection{{Line: 2, Provider: "Custom", Raw: "b.example.com", Reason: RejectReasonInvalidDomain}}, rejections)
+}
+
+func TestStreamDomainListFillsDetails(t *testing.T) {
+	Register("test-no-details", providerFunc(func(_ Env, _ config.ProviderDefinition, emit func(Entry)) error {
+		emit(Entry{Domain: "a.example.com"})
+		emit(Entry{Domain: "b.example.com"})
+		return nil
+	}))
+
+	entries, err := GetDomainList(Env{}, config.ProviderDefinition{Name: "Custom", Type: "test-no-details"})
+	require.NoError(t, err)
+
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		require.NotNil(t, e.Details)
+		assert.Empty(t, e.Comments)
+		assert.Empty(t, e.Tags)
+	}
+}
+
+func TestGetDomainListFiltersAndTransforms(t *testing.T) {
+	var stats Stats
+	entries, err := GetDomainList(Env{Stats: &stats}, config.ProviderDefinition{
+		Content: strings.Join([]string{
+			"www.example.cn",
+			"a.b.c.example.cn",
+			"r1.googlevideo.cn",
+			"ads.example.co.uk",
+			"example.com",
+		}, "\n"),
+		Filter: config.DomainFilter{
+			Include:   config.DomainRules{Suffix: []string{"*.co.uk"}, TLD: []string{".cn"}},
+			Exclude:   config.DomainRules{Regex: []string{`^r[0-9]+\.googlevideo\.`}},
+			MaxLabels: 4,
+		},
+		Name:       "Filtered",
+		Transforms: []config.DomainTransform{config.DomainTransformStripWWW, config.DomainTransformETLDPlusOne},
+		Type:       config.ProviderTypeDomainList,
+	})
+	require.NoError(t, err)
+
+	var domains []string
+	for _, e := range entries {
+		domains = append(domains, e.Domain)
+	}
+
+	assert.Equal(t, []string{"example.cn", "example.co.uk"}, domains)
+	assert.Equal(t, "ads.example.co.uk", entries[1].Original)
+	assert.Equal(t, 3, stats.Filtered)
+	assert.Zero(t, stats.Rejected)
+	assert.Empty(t, stats.RejectedByReason)
+}
+
+func TestGetDomainListUnknownType(t *testing.T) {
+	_, err := GetDomainList(Env{}, config.ProviderDefinition{Type: "unknown"})
+	require.Error(t, err)
+}
+
+// getDomainList executes the given provider directly and collects its
+// entries
+func getDomainList(p Provider, d config.ProviderDefinition) (entries []Entry, err error) {
+	if err = p.GetDomainList(testEnv(), d, func(e Entry) { entries = append(entries, e) }); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func testEnv() Env {
+	return Env{SourceOptions: config.SourceOptions{AppVersion: "testing"}.WithDefaults(), Stats: new(Stats)}
+}
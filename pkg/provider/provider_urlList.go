@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/fqdn"
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+)
+
+type providerURLList struct{}
+
+func init() {
+	Register(config.ProviderTypeURLList, providerURLList{})
+}
+
+func (providerURLList) GetDomainList(env Env, d config.ProviderDefinition, emit func(Entry)) error {
+	var (
+		comments = make(map[string][]string)
+		hosts    []string
+		// urls keeps the distinct URLs of every host, only needed to
+		// count them for min_urls
+		urls map[string]map[string]struct{}
+	)
+
+	if d.MinURLs > 1 {
+		urls = make(map[string]map[string]struct{})
+	}
+
+	if err := readSources(env, d, func(src config.Source, r io.Reader) error {
+		var (
+			comment = sourceComment(d, src)
+			lineNo  int
+			reject  = env.Rejecter(d, src)
+			scanner = bufio.NewScanner(r)
+		)
+
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+
+			if helpers.LineIsComment(line) {
+				continue
+			}
+
+			host, err := hostFromURL(line)
+			if err != nil {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidURL)
+				continue
+			}
+
+			if net.ParseIP(host) != nil {
+				reject(lineNo, scanner.Text(), RejectReasonIPAddress)
+				continue
+			}
+
+			if helpers.IsBlacklisted(host) {
+				reject(lineNo, scanner.Text(), RejectReasonGenericBlacklist)
+				continue
+			}
+
+			if !fqdn.IsValidEntry(host) {
+				reject(lineNo, scanner.Text(), RejectReasonInvalidDomain)
+				continue
+			}
+
+			if _, ok := comments[host]; !ok {
+				// The host is cut from the line which must not be kept
+				host = strings.Clone(host)
+				hosts = append(hosts, host)
+			}
+
+			if urls != nil {
+				if urls[host] == nil {
+					urls[host] = make(map[string]struct{})
+				}
+				urls[host][line] = struct{}{}
+			}
+
+			if !slices.Contains(comments[host], comment) {
+				comments[host] = append(comments[host], comment)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading URL list: %w", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Hosts are rejected after reading all sources, the line is not
+	// known anymore
+	reject := env.Rejecter(d, config.Source{})
+
+	for _, host := range hosts {
+		if urls != nil && len(urls[host]) < d.MinURLs {
+			reject(0, host, RejectReasonTooFewURLs)
+			continue
+		}
+
+		emit(Entry{
+			Domain:  host,
+			Details: &Details{Comments: comments[host]},
+		})
+	}
+
+	return nil
+}
+
+// hostFromURL extracts the lower-cased hostname without port from the
+// given URL, URLs without scheme are treated as HTTP URLs
+func hostFromURL(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("parsing URL: %w", err)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fmt.Errorf("URL has no host")
+	}
+
+	return host, nil
+}
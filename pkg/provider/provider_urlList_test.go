@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+func TestURLListProviderExtractsHosts(t *testing.T) {
+	entries, err := getDomainList(providerURLList{}, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Content: strings.Join([]string{
+			"# OpenPhish feed",
+			"http://evil.example.com/path?x",
+			"https://Evil.Example.com:8443/other#frag",
+			"phish.example.com/login",
+			"http://192.0.2.1/bin.sh",
+			"http://[2001:db8::1]:8080/bin.sh",
+			"http://localhost/",
+		}, "\n"),
+		Name: "OpenPhish",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "evil.example.com", Details: &Details{Comments: []string{"OpenPhish"}}},
+		{Domain: "phish.example.com", Details: &Details{Comments: []string{"OpenPhish"}}},
+	}, entries)
+}
+
+func TestURLListProviderMinURLs(t *testing.T) {
+	entries, err := getDomainList(providerURLList{}, config.ProviderDefinition{
+		Action: config.ProviderActionBlacklist,
+		Content: strings.Join([]string{
+			"http://shared.example.com/a",
+			"http://shared.example.com/a",
+			"http://malicious.example.com/a",
+			"http://malicious.example.com/b",
+		}, "\n"),
+		MinURLs: 2,
+		Name:    "URLhaus",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "malicious.example.com", Details: &Details{Comments: []string{"URLhaus"}}},
+	}, entries)
+}
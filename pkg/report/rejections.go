@@ -0,0 +1,75 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+// RejectionLog writes the lines rejected by the providers as JSON lines
+// into a temporary file which replaces the target file on Commit
+type RejectionLog struct {
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	err  error
+	file *helpers.AtomicFile
+	lock sync.Mutex
+}
+
+// NewRejectionLog creates the temporary file to collect the rejections
+// in before moving it to the given filename
+func NewRejectionLog(filename string) (*RejectionLog, error) {
+	file, err := helpers.CreateAtomic(filename)
+	if err != nil {
+		return nil, fmt.Errorf("creating rejection log: %w", err)
+	}
+
+	buf := bufio.NewWriter(file)
+
+	return &RejectionLog{
+		buf:  buf,
+		enc:  json.NewEncoder(buf),
+		file: file,
+	}, nil
+}
+
+// Add writes the rejection into the log, it is safe for concurrent use
+func (l *RejectionLog) Add(r provider.Rejection) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.err != nil {
+		return
+	}
+
+	if err := l.enc.Encode(r); err != nil {
+		l.err = fmt.Errorf("writing rejection: %w", err)
+	}
+}
+
+// Commit moves the log into place, replacing the previous one
+func (l *RejectionLog) Commit() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	defer l.file.Discard()
+
+	if l.err != nil {
+		return l.err
+	}
+
+	if err := l.buf.Flush(); err != nil {
+		return fmt.Errorf("writing rejections: %w", err)
+	}
+
+	// Rejections are meant to be read by list maintainers / alerting
+	if err := l.file.Commit(0o644); err != nil {
+		return fmt.Errorf("committing rejections: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package report creates a machine readable summary of a blacklist
+// generation run.
+package report
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+	"github.com/Luzifer/named-blacklist/pkg/generator"
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+// List of provider states within the report
+const (
+	StatusError   = "error"
+	StatusOK      = "ok"
+	StatusSkipped = "skipped"
+)
+
+type (
+	// Contribution describes how many entries of the final blacklist
+	// are listed by a provider and whether other providers list them too
+	Contribution struct {
+		// Total entries listed by the provider
+		Total int `json:"total"`
+		// Unique entries only listed by this provider
+		Unique int `json:"unique"`
+		// Shared entries also listed by other providers
+		Shared int `json:"shared"`
+	}
+
+	// Provider contains the results of a single provider
+	Provider struct {
+		Name            string                        `json:"name"`
+		Action          config.ProviderAction         `json:"action"`
+		Type            config.ProviderType           `json:"type"`
+		Weight          float64                       `json:"weight"`
+		Source          string                        `json:"source"`
+		Status          string                        `json:"status"`
+		Cached          bool                          `json:"cached,omitempty"`
+		Error           string                        `json:"error,omitempty"`
+		HTTPStatus      int                           `json:"http_status,omitempty"`
+		DurationSeconds float64                       `json:"duration_seconds"`
+		Bytes           int64                         `json:"bytes"`
+		Entries         int                           `json:"entries"`
+		Duplicates      int                           `json:"duplicates"`
+		Filtered        int                           `json:"filtered"`
+		Rejected        int                           `json:"rejected"`
+		RejectedReasons map[provider.RejectReason]int `json:"rejected_by_reason"`
+		// Expired counts manual entries dropped because they expired
+		Expired int `json:"expired,omitempty"`
+		// Expiring lists manual entries expiring within the expiry
+		// warning of the provider
+		Expiring     []provider.ExpiringEntry `json:"expiring,omitempty"`
+		Contribution Contribution             `json:"contribution"`
+	}
+
+	// Report summarizes a blacklist generation run
+	Report struct {
+		Version         string    `json:"version"`
+		StartedAt       time.Time `json:"started_at"`
+		DurationSeconds float64   `json:"duration_seconds"`
+		Success         bool      `json:"success"`
+		Error           string    `json:"error,omitempty"`
+		// ScoreThreshold is the global threshold used instead of
+		// min_matches, zero when not using weighted scoring
+		ScoreThreshold float64    `json:"score_threshold,omitempty"`
+		Totals         Totals     `json:"totals"`
+		Providers      []Provider `json:"providers"`
+	}
+
+	// Totals contains the overall numbers of the run
+	Totals struct {
+		// Entries contained in the final blacklist
+		Entries int `json:"entries"`
+		// EntriesByScore splits the entries of the final blacklist by
+		// their score (the sum of the weights of the providers listing
+		// them) to help choosing the score thresholds
+		EntriesByScore map[string]int `json:"entries_by_score"`
+		// ProviderEntries is the sum of entries returned by all providers
+		ProviderEntries int `json:"provider_entries"`
+		// Rejected is the sum of lines rejected by all providers
+		Rejected int `json:"rejected"`
+		// ThresholdDrops counts domains dropped because of not reaching
+		// min_matches / score_threshold
+		ThresholdDrops int `json:"threshold_drops"`
+		// WhitelistRemovals counts domains removed by whitelists
+		WhitelistRemovals int `json:"whitelist_removals"`
+		// Outputs maps the output name to the number of entries written
+		Outputs map[string]int `json:"outputs"`
+	}
+)
+
+// New creates the report for a run from the configured providers, the
+// collected stats and the resulting blacklist. The outputs map the
+// output name to the number of entries written into it. Secrets taken
+// from the environment or secret files are redacted from the errors.
+func New(
+	version string,
+	startedAt time.Time,
+	conf *config.File,
+	stats generator.RunStats,
+	blacklist []provider.Entry,
+	outputs map[string]int,
+	runErr error,
+) Report {
+	r := Report{
+		Version:         version,
+		StartedAt:       startedAt,
+		DurationSeconds: stats.Duration.Seconds(),
+		Success:         runErr == nil,
+		ScoreThreshold:  conf.ScoreThreshold,
+		Totals: Totals{
+			Entries:           stats.Entries,
+			EntriesByScore:    entriesByScore(blacklist),
+			ThresholdDrops:    stats.ThresholdDrops,
+			WhitelistRemovals: stats.WhitelistRemovals,
+			Outputs:           outputs,
+		},
+		Providers: make([]Provider, 0, len(conf.Providers)),
+	}
+
+	if runErr != nil {
+		r.Error = conf.Redact(runErr.Error())
+	}
+
+	contributions := contributionsByProvider(blacklist)
+
+	for i, p := range conf.Providers {
+		rp := Provider{
+			Name:         p.Name,
+			Action:       p.Action,
+			Type:         p.Type,
+			Weight:       cmp.Or(p.Weight, 1),
+			Source:       conf.Redact(sourceDescription(p)),
+			Status:       StatusSkipped,
+			Contribution: contributions[p.Name],
+		}
+
+		if i < len(stats.Providers) && stats.Providers[i].Name != "" {
+			ps := stats.Providers[i]
+
+			rp.Status = StatusOK
+			if ps.Error != nil {
+				rp.Status = StatusError
+				rp.Error = conf.Redact(ps.Error.Error())
+			}
+
+			rp.Cached = ps.Cached
+			rp.HTTPStatus = ps.HTTPStatus
+			rp.DurationSeconds = ps.Duration.Seconds()
+			rp.Bytes = ps.Bytes
+			rp.Entries = ps.Entries
+			rp.Duplicates = ps.Duplicates
+			rp.Filtered = ps.Filtered
+			rp.Rejected = ps.Rejected
+			rp.RejectedReasons = ps.RejectedByReason
+			rp.Expired = ps.Expired
+			rp.Expiring = ps.Expiring
+
+			r.Totals.ProviderEntries += ps.Entries
+			r.Totals.Rejected += ps.Rejected
+		}
+
+		r.Providers = append(r.Providers, rp)
+	}
+
+	return r
+}
+
+// WriteFile stores the report as JSON into the given file, replacing
+// it atomically
+func (r Report) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+
+	if err = helpers.AtomicWrite(filename, 0o644, func(w io.Writer) error { //#nosec:G302 // Report is meant to be read by dashboards / alerting
+		_, err := w.Write(data)
+		return err //nolint:wrapcheck // Wrapped below
+	}); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	return nil
+}
+
+func contributionsByProvider(blacklist []provider.Entry) map[string]Contribution {
+	out := make(map[string]Contribution)
+
+	for _, e := range blacklist {
+		for _, name := range e.Providers {
+			c := out[name]
+			c.Total++
+			if len(e.Providers) == 1 {
+				c.Unique++
+			} else {
+				c.Shared++
+			}
+			out[name] = c
+		}
+	}
+
+	return out
+}
+
+// entriesByScore counts the entries having the same score
+func entriesByScore(blacklist []provider.Entry) map[string]int {
+	out := make(map[string]int)
+
+	for _, e := range blacklist {
+		out[strconv.FormatFloat(e.Score, 'f', -1, 64)]++
+	}
+
+	return out
+}
+
+// sanitizeURL removes credentials, query and fragment from the URL as
+// they might contain secrets
+func sanitizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid URL"
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String()
+}
+
+// sourceDescription names the source of the provider without resolving
+// it (no globs expanded, no commands executed)
+func sourceDescription(p config.ProviderDefinition) string {
+	switch {
+	case p.Content != "":
+		return "content"
+	case p.File != "":
+		return p.File
+	case p.URL != "":
+		return sanitizeURL(p.URL)
+	case p.Command != nil:
+		return p.Command.Exec
+	case p.Entries != nil:
+		return "entries"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,421 @@
+// Package state persists the history of blacklisted domains between runs.
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/named-blacklist/pkg/helpers"
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+type (
+	// Store holds the history of all domains listed in previous runs.
+	// Every domain only references a group (the time it was first and
+	// last seen and the providers listing it) shared by all domains
+	// having the same history, which keeps the state of millions of
+	// domains small. The state file is read and written as a stream.
+	Store struct {
+		domains []record
+		file    string
+		groups  []group
+		// providers contains the names of the providers referenced by
+		// the provider sets
+		providers []string
+		sets      [][]uint32
+	}
+
+	// group is the history shared by multiple domains, the timestamps
+	// are stored as Unix seconds
+	group struct {
+		FirstSeen int64  `json:"first_seen"`
+		LastSeen  int64  `json:"last_seen"`
+		Set       uint32 `json:"set"`
+	}
+
+	// record is a domain and the index of its group, records are
+	// sorted by domain
+	record struct {
+		Domain string `json:"d"`
+		Group  uint32 `json:"g"`
+	}
+
+	// interner builds the tables of the store while applying a run,
+	// tables are rebuilt every run to forget unreferenced values
+	interner struct {
+		groupIndex    map[group]uint32
+		groups        []group
+		providerIndex map[string]uint32
+		providers     []string
+		setIndex      map[string]uint32
+		sets          [][]uint32
+	}
+)
+
+// Load reads the state from the given file, a missing file results in
+// an empty store
+func Load(file string) (*Store, error) {
+	s := &Store{file: file}
+
+	f, err := os.Open(file) //#nosec:G304 // Intended to load configured state file
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // File is only read
+
+	if err = s.decode(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("decoding state file: %w", err)
+	}
+
+	return s, nil
+}
+
+// Apply records the currently listed entries in the store and returns
+// them together with the entries which dropped out of all lists less
+// than the grace period ago. The listed entries must be sorted by domain
+// and are modified in place, the result is sorted by domain too.
+// Whitelisted domains are never kept and domains dropped out for longer
+// than the grace period are forgotten. The details of kept entries are
+// created by the details function from the names of the providers which
+// listed them.
+func (s *Store) Apply(
+	listed []provider.Entry,
+	whitelisted func(domain string) bool,
+	details func(providers []string) *provider.Details,
+	now time.Time,
+	grace time.Duration,
+) []provider.Entry {
+	type listedKey struct {
+		details   *provider.Details
+		firstSeen int64
+	}
+
+	var (
+		in      = newInterner()
+		kept    []provider.Entry
+		records = make([]record, 0, len(listed))
+		seen    = now.Unix()
+
+		keptDetails   = make(map[uint32]*provider.Details)
+		keptGroups    = make(map[uint32]uint32)
+		listedDetails = make(map[listedKey]*provider.Details)
+		listedSets    = make(map[*provider.Details]uint32)
+	)
+
+	keep := func(r record) {
+		g := s.groups[r.Group]
+		if whitelisted(r.Domain) || now.Sub(time.Unix(g.LastSeen, 0)) > grace {
+			return
+		}
+
+		newGroup, ok := keptGroups[r.Group]
+		if !ok {
+			newGroup = in.group(g.FirstSeen, g.LastSeen, in.set(s.setNames(g.Set)))
+			keptGroups[r.Group] = newGroup
+		}
+
+		d, ok := keptDetails[r.Group]
+		if !ok {
+			d = details(s.setNames(g.Set))
+			d.FirstSeen = time.Unix(g.FirstSeen, 0).UTC()
+			d.LastSeen = time.Unix(g.LastSeen, 0).UTC()
+			keptDetails[r.Group] = d
+		}
+
+		records = append(records, record{Domain: r.Domain, Group: newGroup})
+		kept = append(kept, provider.Entry{Domain: r.Domain, Original: r.Domain, Details: d})
+	}
+
+	var old int
+	for i, e := range listed {
+		firstSeen := seen
+		for ; old < len(s.domains) && s.domains[old].Domain <= e.Domain; old++ {
+			if s.domains[old].Domain == e.Domain {
+				firstSeen = s.groups[s.domains[old].Group].FirstSeen
+				continue
+			}
+			keep(s.domains[old])
+		}
+
+		var providers []string
+		if e.Details != nil {
+			providers = e.Providers
+		}
+
+		set, ok := listedSets[e.Details]
+		if !ok {
+			set = in.set(providers)
+			listedSets[e.Details] = set
+		}
+
+		// The details are shared with other entries of the same match
+		key := listedKey{details: e.Details, firstSeen: firstSeen}
+		d, ok := listedDetails[key]
+		if !ok {
+			d = new(provider.Details)
+			if e.Details != nil {
+				*d = *e.Details
+			}
+			d.FirstSeen = time.Unix(firstSeen, 0).UTC()
+			d.LastSeen = time.Unix(seen, 0).UTC()
+			listedDetails[key] = d
+		}
+
+		records = append(records, record{Domain: e.Domain, Group: in.group(firstSeen, seen, set)})
+		listed[i].Details = d
+	}
+
+	for ; old < len(s.domains); old++ {
+		keep(s.domains[old])
+	}
+
+	s.domains = records
+	s.groups, s.providers, s.sets = in.groups, in.providers, in.sets
+
+	return mergeSorted(listed, kept)
+}
+
+// Save writes the state back into the file it was loaded from
+func (s *Store) Save() error {
+	if err := helpers.AtomicWrite(s.file, 0o600, func(w io.Writer) error {
+		buf := bufio.NewWriter(w)
+		if err := s.encode(buf); err != nil {
+			return err
+		}
+		return buf.Flush() //nolint:wrapcheck // Wrapped below
+	}); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	return nil
+}
+
+// decode reads the state without reading the whole file into memory,
+// the domains are decoded one after another
+func (s *Store) decode(r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("reading key: %w", err)
+		}
+
+		switch tok {
+		case "domains":
+			if err = expectDelim(dec, '['); err != nil {
+				return err
+			}
+
+			for dec.More() {
+				var rec record
+				if err = dec.Decode(&rec); err != nil {
+					return fmt.Errorf("decoding domain: %w", err)
+				}
+				s.domains = append(s.domains, rec)
+			}
+
+			if err = expectDelim(dec, ']'); err != nil {
+				return err
+			}
+
+		case "groups":
+			err = dec.Decode(&s.groups)
+
+		case "providers":
+			err = dec.Decode(&s.providers)
+
+		case "sets":
+			err = dec.Decode(&s.sets)
+
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+
+		if err != nil {
+			return fmt.Errorf("decoding %v: %w", tok, err)
+		}
+	}
+
+	return s.check()
+}
+
+// encode writes the state, the domains are encoded one after another
+// to not keep the encoded state in memory
+func (s *Store) encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	for _, field := range []struct {
+		prefix string
+		value  any
+	}{
+		{`{"providers":`, s.providers},
+		{`,"sets":`, s.sets},
+		{`,"groups":`, s.groups},
+	} {
+		if _, err := io.WriteString(w, field.prefix); err != nil {
+			return fmt.Errorf("writing state: %w", err)
+		}
+		if err := enc.Encode(field.value); err != nil {
+			return fmt.Errorf("encoding state: %w", err)
+		}
+	}
+
+	if _, err := io.WriteString(w, `,"domains":[`); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	for i, r := range s.domains {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return fmt.Errorf("writing state: %w", err)
+			}
+		}
+
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("encoding domain: %w", err)
+		}
+	}
+
+	if _, err := io.WriteString(w, "]}\n"); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	return nil
+}
+
+// check ensures all references of the decoded state are valid
+func (s *Store) check() error {
+	for _, set := range s.sets {
+		for _, p := range set {
+			if int(p) >= len(s.providers) {
+				return fmt.Errorf("invalid provider reference %d", p)
+			}
+		}
+	}
+
+	for _, g := range s.groups {
+		if int(g.Set) >= len(s.sets) {
+			return fmt.Errorf("invalid set reference %d", g.Set)
+		}
+	}
+
+	for i, r := range s.domains {
+		if int(r.Group) >= len(s.groups) {
+			return fmt.Errorf("invalid group reference %d", r.Group)
+		}
+
+		if i > 0 && s.domains[i-1].Domain >= r.Domain {
+			return fmt.Errorf("domains not sorted at %q", r.Domain)
+		}
+	}
+
+	return nil
+}
+
+// setNames returns the names of the providers of the given set
+func (s *Store) setNames(set uint32) []string {
+	names := make([]string, len(s.sets[set]))
+	for i, p := range s.sets[set] {
+		names[i] = s.providers[p]
+	}
+	return names
+}
+
+func newInterner() *interner {
+	return &interner{
+		groupIndex:    make(map[group]uint32),
+		providerIndex: make(map[string]uint32),
+		setIndex:      make(map[string]uint32),
+	}
+}
+
+func (in *interner) group(firstSeen, lastSeen int64, set uint32) uint32 {
+	g := group{FirstSeen: firstSeen, LastSeen: lastSeen, Set: set}
+	if idx, ok := in.groupIndex[g]; ok {
+		return idx
+	}
+
+	idx := uint32(len(in.groups)) //#nosec:G115 // Number of distinct groups is far below 2^32
+	in.groupIndex[g] = idx
+	in.groups = append(in.groups, g)
+
+	return idx
+}
+
+func (in *interner) set(providers []string) uint32 {
+	set := make([]uint32, len(providers))
+	key := make([]byte, 0, 4*len(providers))
+
+	for i, name := range providers {
+		idx, ok := in.providerIndex[name]
+		if !ok {
+			idx = uint32(len(in.providers)) //#nosec:G115 // Provider count is far below 2^32
+			in.providerIndex[name] = idx
+			in.providers = append(in.providers, name)
+		}
+
+		set[i] = idx
+		key = binary.LittleEndian.AppendUint32(key, idx)
+	}
+
+	if idx, ok := in.setIndex[string(key)]; ok {
+		return idx
+	}
+
+	idx := uint32(len(in.sets)) //#nosec:G115 // Number of distinct sets is far below 2^32
+	in.setIndex[string(key)] = idx
+	in.sets = append(in.sets, set)
+
+	return idx
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", want, err)
+	}
+
+	if tok != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+
+	return nil
+}
+
+// mergeSorted merges the sorted kept entries into the sorted listed
+// entries, working from the end to not need another copy of the list
+func mergeSorted(listed, kept []provider.Entry) []provider.Entry {
+	n := len(listed)
+	listed = append(listed, kept...)
+
+	i, j := n-1, len(kept)-1
+	for k := len(listed) - 1; j >= 0; k-- {
+		if i >= 0 && strings.Compare(listed[i].Domain, kept[j].Domain) > 0 {
+			listed[k] = listed[i]
+			i--
+			continue
+		}
+
+		listed[k] = kept[j]
+		j--
+	}
+
+	return listed
+}
@@ -0,0 +1,125 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Luzifer/named-blacklist/pkg/provider"
+)
+
+func TestApplyKeepsEntriesDuringGracePeriod(t *testing.T) {
+	var (
+		file        = filepath.Join(t.TempDir(), "state.json")
+		start       = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		grace       = 6 * time.Hour
+		whitelisted = func(domain string) bool { return domain == "whitelisted.example.com" }
+		details     = func(providers []string) *provider.Details {
+			return &provider.Details{Comments: providers, Providers: providers, Score: float64(len(providers))}
+		}
+	)
+
+	s, err := Load(file)
+	require.NoError(t, err)
+
+	listed := s.Apply([]provider.Entry{
+		{Domain: "flapping.example.com", Details: &provider.Details{Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1}},
+		{Domain: "stable.example.com", Details: &provider.Details{Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1}},
+		{Domain: "whitelisted.example.com", Details: &provider.Details{Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1}},
+	}, whitelisted, details, start, grace)
+	require.Len(t, listed, 3)
+	assert.Equal(t, start, listed[0].FirstSeen)
+	assert.Equal(t, start, listed[0].LastSeen)
+	require.NoError(t, s.Save())
+
+	// Reload to ensure the state survives between runs
+	s, err = Load(file)
+	require.NoError(t, err)
+
+	listed = s.Apply([]provider.Entry{
+		{Domain: "stable.example.com", Details: &provider.Details{Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1}},
+	}, whitelisted, details, start.Add(time.Hour), grace)
+
+	assert.Equal(t, []provider.Entry{
+		{Domain: "flapping.example.com", Original: "flapping.example.com", Details: &provider.Details{
+			Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1,
+			FirstSeen: start, LastSeen: start,
+		}},
+		{Domain: "stable.example.com", Details: &provider.Details{
+			Comments: []string{"Feed"}, Providers: []string{"Feed"}, Score: 1,
+			FirstSeen: start, LastSeen: start.Add(time.Hour),
+		}},
+	}, listed)
+	assert.Equal(t, []string{"flapping.example.com", "stable.example.com"}, stored(s))
+
+	listed = s.Apply(nil, whitelisted, details, start.Add(grace+time.Minute), grace)
+	assert.Equal(t, []string{"stable.example.com"}, domains(listed))
+	assert.Equal(t, []string{"stable.example.com"}, stored(s))
+}
+
+func TestApplySharesDetails(t *testing.T) {
+	var (
+		now       = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		shared    = &provider.Details{Comments: []string{"A"}, Providers: []string{"A", "B"}, Score: 2}
+		noDetails = func([]string) *provider.Details { return new(provider.Details) }
+		never     = func(string) bool { return false }
+	)
+
+	s, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+
+	listed := s.Apply([]provider.Entry{
+		{Domain: "a.example.com", Details: shared},
+		{Domain: "b.example.com", Details: shared},
+		{Domain: "c.example.com", Details: shared},
+	}, never, noDetails, now, time.Hour)
+
+	// Entries of the same match first seen in the same run share their
+	// details and their group, the passed details are not modified
+	assert.Same(t, listed[0].Details, listed[1].Details)
+	assert.Same(t, listed[0].Details, listed[2].Details)
+	assert.True(t, shared.FirstSeen.IsZero())
+	assert.Len(t, s.groups, 1)
+	assert.Equal(t, []string{"A", "B"}, s.providers)
+
+	require.NoError(t, s.Save())
+	s, err = Load(s.file)
+	require.NoError(t, err)
+
+	listed = s.Apply([]provider.Entry{
+		{Domain: "a.example.com", Details: shared},
+		{Domain: "d.example.com", Details: shared},
+	}, never, noDetails, now.Add(time.Minute), time.Hour)
+
+	require.Len(t, listed, 4)
+	assert.Equal(t, now, listed[0].FirstSeen)
+	assert.Equal(t, now.Add(time.Minute), listed[3].FirstSeen)
+	assert.Same(t, listed[1].Details, listed[2].Details, "kept entries share details")
+	assert.Len(t, s.groups, 3)
+}
+
+func TestLoadRejectsInvalidReferences(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"providers":[],"sets":[[]],"groups":[],"domains":[{"d":"a.example.com","g":0}]}`), 0o600))
+
+	_, err := Load(file)
+	require.ErrorContains(t, err, "invalid group reference 0")
+}
+
+func stored(s *Store) (out []string) {
+	for _, r := range s.domains {
+		out = append(out, r.Domain)
+	}
+	return out
+}
+
+func domains(entries []provider.Entry) (out []string) {
+	for _, e := range entries {
+		out = append(out, e.Domain)
+	}
+	return out
+}
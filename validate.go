@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Luzifer/named-blacklist/pkg/config"
+)
+
+// runValidate loads the config file and prints all problems found in it
+func runValidate() error {
+	_, err := config.LoadConfigFile(cfg.Config)
+
+	var problems config.ValidationErrors
+	switch {
+	case err == nil:
+		fmt.Printf("%s: config is valid\n", cfg.Config) //nolint:forbidigo // printing the result to stdout is fine
+		return nil
+
+	case errors.As(err, &problems):
+		for _, p := range problems {
+			fmt.Println(p.Error()) //nolint:forbidigo // printing the result to stdout is fine
+		}
+		return fmt.Errorf("found %d problem(s)", len(problems))
+
+	default:
+		return fmt.Errorf("loading config: %w", err)
+	}
+}